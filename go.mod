@@ -3,15 +3,26 @@ module newsmere
 go 1.19
 
 require (
+	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
 	gorm.io/datatypes v1.0.7
+	gorm.io/driver/mysql v1.3.6
+	gorm.io/driver/postgres v1.3.5
 	gorm.io/driver/sqlite v1.3.6
 	gorm.io/gorm v1.23.9
 )
 
 require (
 	github.com/go-sql-driver/mysql v1.6.0 // indirect
+	github.com/jackc/chunkreader/v2 v2.0.1 // indirect
+	github.com/jackc/pgconn v1.12.0 // indirect
+	github.com/jackc/pgio v1.0.0 // indirect
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgproto3/v2 v2.3.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20200714003250-2b9c44734f2b // indirect
+	github.com/jackc/pgtype v1.11.0 // indirect
+	github.com/jackc/pgx/v4 v4.16.0 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/mattn/go-sqlite3 v1.14.15 // indirect
-	gorm.io/driver/mysql v1.3.6 // indirect
+	golang.org/x/text v0.3.7 // indirect
 )
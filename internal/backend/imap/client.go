@@ -0,0 +1,316 @@
+package imap
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const internalDateLayout = "_2-Jan-2006 15:04:05 -0700"
+
+// NewClient creates an imap client connected to addr.
+func NewClient(network, addr string) (*IMAPClient, error) {
+	return NewDialerClient(&net.Dialer{}, network, addr)
+}
+
+// NewDialerClient creates an imap client connected to addr with dialer,
+// e.g. one limiting the time to connect.
+func NewDialerClient(dialer *net.Dialer, network, addr string) (*IMAPClient, error) {
+	netconn, err := dialer.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return connect(netconn)
+}
+
+// NewConnClient wraps an existing connection, for example one opened with
+// tls.Dial.
+func NewConnClient(netconn net.Conn) (*IMAPClient, error) {
+	client, err := connect(netconn)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := netconn.(*tls.Conn); ok {
+		client.tls = true
+	}
+	return client, nil
+}
+
+func connect(netconn net.Conn) (*IMAPClient, error) {
+	conn := textproto.NewConn(netconn)
+	line, err := conn.ReadLine()
+	if err != nil {
+		netconn.Close()
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "* OK") && !strings.HasPrefix(line, "* PREAUTH") {
+		netconn.Close()
+		return nil, errors.New("unexpected greeting: " + line)
+	}
+
+	return &IMAPClient{
+		conn:    conn,
+		netconn: netconn,
+		Banner:  line,
+	}, nil
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s", e.Status, e.Msg)
+}
+
+// Logout ends the session and closes the connection.
+func (c *IMAPClient) Logout() error {
+	_, _ = c.Command(nil, "LOGOUT")
+	return c.conn.Close()
+}
+
+// Close the connection, it is safe to call while a command is running.
+func (c *IMAPClient) Close() error {
+	return c.conn.Close()
+}
+
+// Command sends a tagged command and reads responses until its completion.
+// Untagged responses are handed to the callback, which must consume any
+// literal announced at the end of the line.
+func (c *IMAPClient) Command(untagged func(line string) error,
+	format string, args ...interface{}) (string, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%04d", c.tag)
+
+	if c.Timeout > 0 {
+		if err := c.netconn.SetDeadline(time.Now().Add(c.Timeout)); err != nil {
+			return "", err
+		}
+		defer c.netconn.SetDeadline(time.Time{})
+	}
+
+	err := c.conn.PrintfLine(tag+" "+format, args...)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		line, err := c.conn.ReadLine()
+		if err != nil {
+			return "", err
+		}
+
+		if strings.HasPrefix(line, tag+" ") {
+			parts := strings.SplitN(line[len(tag)+1:], " ", 2)
+			msg := ""
+			if len(parts) > 1 {
+				msg = parts[1]
+			}
+			if strings.ToUpper(parts[0]) != "OK" {
+				return "", &Error{Status: strings.ToUpper(parts[0]), Msg: msg}
+			}
+			return msg, nil
+		}
+
+		if untagged != nil && strings.HasPrefix(line, "* ") {
+			if err := untagged(line[2:]); err != nil {
+				return "", err
+			}
+		}
+	}
+}
+
+// Login authenticates with user name and password.
+func (c *IMAPClient) Login(user, pass string) error {
+	_, err := c.Command(nil, "LOGIN %s %s", quote(user), quote(pass))
+	return err
+}
+
+// Examine opens a mailbox read-only, so that importing never changes the
+// flags of messages.
+func (c *IMAPClient) Examine(name string) (*Mailbox, error) {
+	mbox := &Mailbox{Name: name}
+
+	_, err := c.Command(func(line string) error {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) >= 2 && strings.ToUpper(fields[1]) == "EXISTS":
+			mbox.Exists, _ = strconv.ParseInt(fields[0], 10, 64)
+		case len(fields) >= 3 && strings.ToUpper(fields[1]) == "[UIDVALIDITY":
+			mbox.UIDValidity, _ = strconv.ParseInt(strings.TrimSuffix(fields[2], "]"), 10, 64)
+		case len(fields) >= 3 && strings.ToUpper(fields[1]) == "[UIDNEXT":
+			mbox.UIDNext, _ = strconv.ParseInt(strings.TrimSuffix(fields[2], "]"), 10, 64)
+		}
+		return nil
+	}, "EXAMINE %s", quote(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return mbox, nil
+}
+
+// FetchInfo lists the messages whose UID is at least from, ordered by UID.
+func (c *IMAPClient) FetchInfo(from int64) ([]MessageInfo, error) {
+	var infos []MessageInfo
+
+	_, err := c.Command(func(line string) error {
+		items, ok := fetchItems(line)
+		if !ok {
+			return nil
+		}
+
+		var info MessageInfo
+		for i := 0; i+1 < len(items); i += 2 {
+			switch strings.ToUpper(items[i]) {
+			case "UID":
+				info.UID, _ = strconv.ParseInt(items[i+1], 10, 64)
+			case "RFC822.SIZE":
+				info.Size, _ = strconv.Atoi(items[i+1])
+			case "INTERNALDATE":
+				info.InternalDate, _ = time.Parse(internalDateLayout, items[i+1])
+			}
+		}
+
+		// "n:*" always matches the last message, even below n
+		if info.UID >= from {
+			infos = append(infos, info)
+		}
+		return nil
+	}, "UID FETCH %d:* (UID RFC822.SIZE INTERNALDATE)", from)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].UID < infos[j].UID })
+	return infos, nil
+}
+
+// FetchBody streams the raw message with the given UID into fn. Messages
+// which disappeared in the meantime are silently skipped.
+func (c *IMAPClient) FetchBody(uid int64, fn func(r io.Reader) error) error {
+	var fnErr error
+
+	_, err := c.Command(func(line string) error {
+		size, ok := literalSize(line)
+		if !ok {
+			return nil
+		}
+
+		r := io.LimitReader(c.conn.R, size)
+		if fnErr == nil {
+			fnErr = fn(r)
+		}
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return err
+		}
+
+		// the rest of the fetch response follows the literal
+		_, err := c.conn.ReadLine()
+		return err
+	}, "UID FETCH %d (BODY.PEEK[])", uid)
+	if err != nil {
+		return err
+	}
+
+	return fnErr
+}
+
+// fetchItems splits the attribute list of an untagged FETCH response.
+func fetchItems(line string) ([]string, bool) {
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) < 3 || strings.ToUpper(fields[1]) != "FETCH" {
+		return nil, false
+	}
+
+	list := strings.TrimSpace(fields[2])
+	if !strings.HasPrefix(list, "(") || !strings.HasSuffix(list, ")") {
+		return nil, false
+	}
+
+	return tokenize(list[1 : len(list)-1]), true
+}
+
+// tokenize splits atoms, quoted strings and parenthesized lists, the
+// latter are returned as a single token.
+func tokenize(s string) []string {
+	var (
+		tokens []string
+		cur    strings.Builder
+		quoted bool
+		escape bool
+		depth  int
+	)
+
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+		}
+	}
+
+	for _, r := range s {
+		switch {
+		case escape:
+			cur.WriteRune(r)
+			escape = false
+		case quoted && r == '\\':
+			escape = true
+		case r == '"' && depth == 0:
+			if quoted {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+			}
+			quoted = !quoted
+		case quoted:
+			cur.WriteRune(r)
+		case r == '(':
+			depth++
+			cur.WriteRune(r)
+		case r == ')':
+			depth--
+			cur.WriteRune(r)
+		case r == ' ' && depth == 0:
+			flush()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+// literalSize parses the {n} announcement at the end of a line.
+func literalSize(line string) (int64, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false
+	}
+
+	i := strings.LastIndex(line, "{")
+	if i < 0 {
+		return 0, false
+	}
+
+	size, err := strconv.ParseInt(line[i+1:len(line)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return size, true
+}
+
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// HasTLS checks whether tls is used.
+func (c *IMAPClient) HasTLS() bool {
+	return c.tls
+}
@@ -0,0 +1,131 @@
+package imap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+const testMessage = "Message-ID: <1@example.com>\r\nSubject: hello\r\n\r\nbody\r\n"
+
+// fakeServer answers the commands of a single session with canned
+// responses, INBOX has the given UIDVALIDITY.
+func fakeServer(conn net.Conn, validity int) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "* OK fake server ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		tag, cmd := fields[0], strings.ToUpper(strings.Join(fields[1:], " "))
+		switch {
+		case strings.HasPrefix(cmd, `LOGIN "USER" "SE\"CRET"`):
+			fmt.Fprintf(conn, "%s OK logged in\r\n", tag)
+		case strings.HasPrefix(cmd, "LOGIN"):
+			fmt.Fprintf(conn, "%s NO invalid credentials\r\n", tag)
+		case strings.HasPrefix(cmd, `EXAMINE "INBOX"`):
+			fmt.Fprintf(conn, "* 2 EXISTS\r\n* 0 RECENT\r\n"+
+				"* OK [UIDVALIDITY %d] UIDs valid\r\n"+
+				"* OK [UIDNEXT 8] predicted next UID\r\n"+
+				"%s OK [READ-ONLY] done\r\n", validity, tag)
+		case strings.HasPrefix(cmd, "UID FETCH 1:*"),
+			strings.HasPrefix(cmd, "UID FETCH 7:*"):
+			fmt.Fprintf(conn, "* 2 FETCH (UID 7 RFC822.SIZE %d "+
+				"INTERNALDATE \"02-Jan-2006 15:04:05 +0000\")\r\n"+
+				"%s OK done\r\n", len(testMessage), tag)
+		case strings.HasPrefix(cmd, "UID FETCH 8:*"),
+			strings.HasPrefix(cmd, "UID FETCH 9:*"):
+			fmt.Fprintf(conn, "* 2 FETCH (UID 7 RFC822.SIZE 1)\r\n%s OK done\r\n", tag)
+		case strings.HasPrefix(cmd, "UID FETCH 7 "):
+			fmt.Fprintf(conn, "* 2 FETCH (UID 7 BODY[] {%d}\r\n%s)\r\n%s OK done\r\n",
+				len(testMessage), testMessage, tag)
+		case strings.HasPrefix(cmd, "LOGOUT"):
+			fmt.Fprintf(conn, "* BYE\r\n%s OK bye\r\n", tag)
+			return
+		default:
+			fmt.Fprintf(conn, "%s BAD unknown command\r\n", tag)
+		}
+	}
+}
+
+func newTestClient(t *testing.T) *IMAPClient {
+	return newValidityClient(t, 1234)
+}
+
+// newValidityClient connects to a fake server with the given UIDVALIDITY.
+func newValidityClient(t *testing.T, validity int) *IMAPClient {
+	server, conn := net.Pipe()
+	go fakeServer(server, validity)
+
+	client, err := NewConnClient(conn)
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	return client
+}
+
+func TestLogin(t *testing.T) {
+	client := newTestClient(t)
+	defer client.Close()
+
+	if err := client.Login("user", "wrong"); err == nil {
+		t.Errorf("Expected login to fail")
+	}
+
+	if err := client.Login("user", `se"cret`); err != nil {
+		t.Errorf("Error logging in: %v", err)
+	}
+}
+
+func TestExamineAndFetch(t *testing.T) {
+	client := newTestClient(t)
+	defer client.Close()
+
+	mbox, err := client.Examine("INBOX")
+	if err != nil {
+		t.Fatalf("Error examining mailbox: %v", err)
+	}
+	if mbox.Exists != 2 || mbox.UIDValidity != 1234 || mbox.UIDNext != 8 {
+		t.Errorf("Unexpected mailbox: %+v", mbox)
+	}
+
+	infos, err := client.FetchInfo(7)
+	if err != nil {
+		t.Fatalf("Error fetching info: %v", err)
+	}
+	if len(infos) != 1 || infos[0].UID != 7 || infos[0].Size != len(testMessage) ||
+		infos[0].InternalDate.Year() != 2006 {
+		t.Fatalf("Unexpected infos: %+v", infos)
+	}
+
+	// the last message is always returned for n:*
+	infos, err = client.FetchInfo(9)
+	if err != nil || len(infos) != 0 {
+		t.Errorf("Unexpected infos: %+v, %v", infos, err)
+	}
+
+	var body []byte
+	err = client.FetchBody(7, func(r io.Reader) error {
+		body, err = io.ReadAll(r)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error fetching body: %v", err)
+	}
+	if string(body) != testMessage {
+		t.Errorf("Unexpected body: %q", body)
+	}
+
+	// the session must still be in sync after the literal
+	if _, err := client.Examine("INBOX"); err != nil {
+		t.Errorf("Error after fetch: %v", err)
+	}
+}
@@ -1,6 +1,21 @@
 package imap
 
-import "encoding/json"
+import (
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"newsmere/internal/storage"
+	"newsmere/internal/types"
+	"strings"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
 
 func New(config json.RawMessage) (*Backend, error) {
 	backend := new(Backend)
@@ -8,6 +23,325 @@ func New(config json.RawMessage) (*Backend, error) {
 	return backend, err
 }
 
-func (Backend) Type() string {
+func (*Backend) Type() string {
 	return Type
 }
+
+func (b *Backend) Start() error {
+	fmt.Printf("[Backend] %s-%s starting\n", b.Type(), b.Name)
+	return nil
+}
+
+// Sync imports new messages of all mailboxes.
+func (b *Backend) Sync() error {
+	err := b.syncMailboxes()
+	fmt.Printf("[Backend] %s-%s sync mailboxes finished\n", b.Type(), b.Name)
+	return err
+}
+
+// Stop closes the connection, a sync in progress fails on its next
+// command.
+func (b *Backend) Stop() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.client == nil {
+		return nil
+	}
+
+	err := b.client.Close()
+	b.client = nil
+
+	return err
+}
+
+func (b *Backend) Restart() error {
+	if err := b.Stop(); err != nil {
+		return err
+	}
+
+	if err := b.Start(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (b *Backend) Status() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.client == nil {
+		return types.StatusDown
+	}
+
+	return types.StatusUp
+}
+
+func (b *Backend) ensureClient() (*IMAPClient, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.client != nil {
+		return b.client, nil
+	}
+
+	port := b.Port
+	if port == 0 {
+		port = 143
+		if b.TLS {
+			port = 993
+		}
+	}
+	addr := fmt.Sprintf("%s:%d", b.Server, port)
+
+	var (
+		client *IMAPClient
+		err    error
+	)
+	dialer := &net.Dialer{Timeout: b.timeout()}
+	if b.TLS {
+		conn, err := tls.DialWithDialer(dialer, "tcp", addr,
+			&tls.Config{ServerName: b.Server})
+		if err != nil {
+			return nil, err
+		}
+		client, err = NewConnClient(conn)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		client, err = NewDialerClient(dialer, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	client.Timeout = b.timeout()
+
+	if b.User != "" {
+		if err := client.Login(b.User, b.Pass); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	b.client = client
+	return client, nil
+}
+
+func (b *Backend) timeout() time.Duration {
+	if b.Timeout <= 0 {
+		return defaultTimeout * time.Second
+	}
+	return time.Duration(b.Timeout) * time.Second
+}
+
+// dropClient closes the client unless the error is a completion of the
+// server, after which the connection is still usable.
+func (b *Backend) dropClient(client *IMAPClient, err error) {
+	var imapErr *Error
+	if err == nil || errors.As(err, &imapErr) {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	client.Close()
+	if b.client == client {
+		b.client = nil
+	}
+}
+
+func (b *Backend) syncMailboxes() error {
+	client, err := b.ensureClient()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range b.Mailboxes {
+		if err := b.syncMailbox(client, m); err != nil {
+			b.dropClient(client, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// groupName returns the served group name of a mailbox.
+func groupName(config MailboxConfig) string {
+	if config.Name != "" {
+		return config.Name
+	}
+
+	name := strings.ToLower(config.Mailbox)
+	return strings.NewReplacer("/", ".", " ", "-").Replace(name)
+}
+
+// ensureGroup creates the subscription and the served group of a mailbox.
+func (b *Backend) ensureGroup(config MailboxConfig) (
+	*storage.Subscription, *storage.Group, error) {
+	db := storage.GetDb()
+	name := groupName(config)
+
+	sub := storage.Subscription{
+		Name:        name,
+		Description: config.Mailbox,
+		Source:      b.Name,
+	}
+	result := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}, {Name: "source"}},
+		DoNothing: true,
+	}).Create(&sub)
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+
+	group := storage.Group{
+		Name:        name,
+		Description: config.Mailbox,
+		Source:      b.Name,
+	}
+	result = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}, {Name: "source"}},
+		DoNothing: true,
+	}).Create(&group)
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+
+	if err := db.Where("name = ? AND source = ?", name, b.Name).First(&sub).Error; err != nil {
+		return nil, nil, err
+	}
+	if err := db.Where("name = ? AND source = ?", name, b.Name).First(&group).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return &sub, &group, nil
+}
+
+// syncMailbox imports the messages above the last imported UID. When the
+// UIDVALIDITY of the mailbox changed, its UIDs mean something else now and
+// the whole mailbox is imported again, already stored messages are skipped
+// by their message-id.
+func (b *Backend) syncMailbox(client *IMAPClient, config MailboxConfig) error {
+	sub, group, err := b.ensureGroup(config)
+	if err != nil {
+		return err
+	}
+
+	if !group.Enabled {
+		return nil
+	}
+
+	mbox, err := client.Examine(config.Mailbox)
+	if err != nil {
+		return err
+	}
+
+	if int(mbox.UIDValidity) != sub.Validity {
+		sub.Validity = int(mbox.UIDValidity)
+		sub.Fetched = 0
+	}
+	if mbox.UIDNext > 0 {
+		sub.High = int(mbox.UIDNext - 1)
+	}
+	if err := saveProgress(sub); err != nil {
+		return err
+	}
+
+	infos, err := client.FetchInfo(int64(sub.Fetched) + 1)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		// read before storing, the transaction mustn't wait for the
+		// network
+		var msg io.ReadCloser
+		err := client.FetchBody(info.UID, func(r io.Reader) error {
+			var err error
+			msg, err = storage.Spool(r)
+			return err
+		})
+		if err != nil {
+			if msg != nil {
+				msg.Close()
+			}
+			return err
+		}
+
+		// messages which disappeared in the meantime are skipped
+		if msg != nil {
+			err = b.saveMessage(group, info, msg)
+			msg.Close()
+			if err != nil {
+				return err
+			}
+		}
+
+		sub.Fetched = int(info.UID)
+		if err := saveProgress(sub); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func saveProgress(sub *storage.Subscription) error {
+	return storage.GetDb().Model(sub).Updates(map[string]interface{}{
+		"high":     sub.High,
+		"fetched":  sub.Fetched,
+		"validity": sub.Validity,
+	}).Error
+}
+
+// saveMessage stores a raw RFC 5322 message into the group.
+func (b *Backend) saveMessage(group *storage.Group, info MessageInfo, r io.Reader) error {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return err
+	}
+
+	header := msg.Header
+	msgID := strings.TrimSpace(header.Get("Message-Id"))
+	if msgID == "" {
+		// keep it stable across UIDVALIDITY resets
+		sum := sha1.Sum([]byte(header.Get("Date") + header.Get("From") +
+			header.Get("Subject")))
+		msgID = fmt.Sprintf("<%x@%s.%s>", sum, group.Name, b.Name)
+		header["Message-Id"] = []string{msgID}
+	}
+	if header.Get("Newsgroups") == "" {
+		header["Newsgroups"] = []string{group.Source + "." + group.Name}
+	}
+
+	date, err := header.Date()
+	if err != nil {
+		date = info.InternalDate
+	}
+
+	headers, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	article := storage.Article{
+		MsgID:      msgID,
+		DocType:    Type,
+		Title:      header.Get("Subject"),
+		Author:     header.Get("From"),
+		PostedAt:   date,
+		References: header.Get("References"),
+		Headers:    headers,
+	}
+
+	err = storage.SaveArticle(storage.GetDb(), &article, []uint{group.ID},
+		msg.Body)
+	if errors.Is(err, storage.ErrArticleExists) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+package imap
+
+import (
+	"errors"
+	"newsmere/internal/storage"
+	"newsmere/internal/types"
+	"testing"
+)
+
+func TestSyncMailbox(t *testing.T) {
+	if err := storage.Open(storage.Config{DSN: ":memory:"}); err != nil {
+		t.Fatalf("Error opening storage: %v", err)
+	}
+	defer storage.Close()
+	db := storage.GetDb()
+
+	b := &Backend{Name: "mail"}
+	config := MailboxConfig{Mailbox: "INBOX"}
+
+	for _, tc := range []struct {
+		name     string
+		validity int
+		// drop deletes the stored articles before syncing
+		drop     bool
+		articles int
+	}{
+		{"first", 1234, false, 1},
+		{"unchanged", 1234, true, 0},
+		// the mailbox is imported again
+		{"new validity", 999, false, 1},
+		{"reimport", 555, true, 1},
+	} {
+		if tc.drop {
+			db.Where("1 = 1").Delete(&storage.GroupArticle{})
+			db.Unscoped().Where("1 = 1").Delete(&storage.Article{})
+		}
+
+		client := newValidityClient(t, tc.validity)
+		if err := b.syncMailbox(client, config); err != nil {
+			t.Fatalf("%s: Error syncing: %v", tc.name, err)
+		}
+		client.Close()
+
+		var sub storage.Subscription
+		db.Where("name = ? AND source = ?", "inbox", b.Name).First(&sub)
+		if sub.Validity != tc.validity || sub.Fetched != 7 || sub.High != 7 {
+			t.Errorf("%s: Unexpected subscription: %+v", tc.name, sub)
+		}
+
+		var articles []storage.Article
+		db.Preload("Groups").Find(&articles)
+		if len(articles) != tc.articles {
+			t.Errorf("%s: Expected %d articles, got %+v", tc.name, tc.articles, articles)
+		}
+		for _, a := range articles {
+			if a.MsgID != "<1@example.com>" || a.Title != "hello" || len(a.Groups) != 1 {
+				t.Errorf("%s: Unexpected article: %+v", tc.name, a)
+			}
+		}
+	}
+}
+
+func TestDropClient(t *testing.T) {
+	b := &Backend{Name: "mail"}
+
+	// protocol errors keep the connection
+	b.client = newTestClient(t)
+	b.dropClient(b.client, &Error{Status: "NO", Msg: "no such mailbox"})
+	if b.Status() != types.StatusUp {
+		t.Errorf("Client dropped on a protocol error")
+	}
+
+	b.dropClient(b.client, errors.New("connection reset"))
+	if b.Status() != types.StatusDown {
+		t.Errorf("Client kept on a connection error")
+	}
+
+	// syncing on a broken connection drops it as well
+	b.client = newTestClient(t)
+	b.client.Close()
+	b.Mailboxes = []MailboxConfig{{Mailbox: "INBOX"}}
+	if err := storage.Open(storage.Config{DSN: ":memory:"}); err != nil {
+		t.Fatalf("Error opening storage: %v", err)
+	}
+	defer storage.Close()
+	if err := b.Sync(); err == nil {
+		t.Errorf("Expected sync to fail")
+	}
+	if b.Status() != types.StatusDown {
+		t.Errorf("Client kept after a failed sync")
+	}
+}
@@ -1,7 +1,69 @@
 package imap
 
+import (
+	"net"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
 const Type = "imap"
 
+// defaultTimeout is the number of seconds a command may take unless
+// configured otherwise.
+const defaultTimeout = 60
+
+// Mailbox is the state of a selected mailbox.
+type Mailbox struct {
+	Name        string
+	Exists      int64
+	UIDValidity int64
+	UIDNext     int64
+}
+
+// MessageInfo describes a message without its content.
+type MessageInfo struct {
+	UID          int64
+	Size         int
+	InternalDate time.Time
+}
+
+// Error is a NO or BAD completion of a command.
+type Error struct {
+	Status string
+	Msg    string
+}
+
+// IMAPClient is a minimal IMAP4rev1 client, it only knows what is needed
+// to import messages.
+type IMAPClient struct {
+	conn    *textproto.Conn
+	netconn net.Conn
+	tls     bool
+	tag     int
+	Banner  string
+	// Timeout limits each command, zero means no limit.
+	Timeout time.Duration
+}
+
+// MailboxConfig maps a mailbox to a served group, the group name defaults
+// to the mailbox name with hierarchy separators turned into dots.
+type MailboxConfig struct {
+	Mailbox string `json:"mailbox"`
+	Name    string `json:"name,omitempty"`
+}
+
 type Backend struct {
-	Name string `json:"name"`
+	Name      string          `json:"name"`
+	User      string          `json:"user,omitempty"`
+	Pass      string          `json:"pass,omitempty"`
+	Server    string          `json:"server"`
+	Port      int             `json:"port,omitempty"`
+	TLS       bool            `json:"tls,omitempty"`
+	Mailboxes []MailboxConfig `json:"mailboxes"`
+	// Timeout is the number of seconds a command may take.
+	Timeout int `json:"timeout,omitempty"`
+
+	mu     sync.Mutex
+	client *IMAPClient
 }
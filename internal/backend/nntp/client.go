@@ -1,28 +1,38 @@
 package nntp
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"io"
 	"net"
+	"net/mail"
 	"net/textproto"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// New for create a nntp client to connect to server
-func NewClient(network, addr string) (*NNTPClient, error) {
-	netconn, err := net.Dial(network, addr)
+// New for create a nntp client to connect to server, ctx bounds the dial
+// and the greeting.
+func NewClient(ctx context.Context, network, addr string) (*NNTPClient, error) {
+	var dialer net.Dialer
+	netconn, err := dialer.DialContext(ctx, network, addr)
 	if err != nil {
 		return nil, err
 	}
 
-	return connect(netconn)
+	client, err := connect(ctx, netconn)
+	if err != nil {
+		netconn.Close()
+		return nil, err
+	}
+	return client, nil
 }
 
 // NewConn wraps an existing connection, for example one opened with tls.Dial
-func NewConnClient(netconn net.Conn) (*NNTPClient, error) {
-	client, err := connect(netconn)
+func NewConnClient(ctx context.Context, netconn net.Conn) (*NNTPClient, error) {
+	client, err := connect(ctx, netconn)
 	if err != nil {
 		return nil, err
 	}
@@ -32,18 +42,25 @@ func NewConnClient(netconn net.Conn) (*NNTPClient, error) {
 	return client, nil
 }
 
-func connect(netconn net.Conn) (*NNTPClient, error) {
-	conn := textproto.NewConn(netconn)
-	_, msg, err := conn.ReadCodeLine(200)
+func connect(ctx context.Context, netconn net.Conn) (*NNTPClient, error) {
+	c := &NNTPClient{
+		conn:    textproto.NewConn(netconn),
+		netconn: netconn,
+	}
+
+	end, err := c.begin(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer end()
 
-	return &NNTPClient{
-		conn:    conn,
-		netconn: netconn,
-		Banner:  msg,
-	}, nil
+	// 200 or 201, depending on whether posting is allowed
+	_, msg, err := c.conn.ReadCodeLine(20)
+	if err != nil {
+		return nil, c.ctxErr(ctx, err)
+	}
+	c.Banner = msg
+	return c, nil
 }
 
 // CLose the nntp client
@@ -51,48 +68,109 @@ func (c *NNTPClient) Close() error {
 	return c.conn.Close()
 }
 
-// Authenticate against an NNTP server using authinfo user/pass.
-func (c *NNTPClient) Authenticate(user, pass string) (msg string, err error) {
-	err = c.conn.PrintfLine("authinfo user %s", user)
-	if err != nil {
-		return
+// begin sets the deadline of a command, the earlier of Timeout and the
+// deadline of ctx, and interrupts the command when ctx is canceled. The
+// returned func has to be called once the command returns; the deadline
+// stays in place for reading the rest of its response.
+func (c *NNTPClient) begin(ctx context.Context) (end func(), err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	_, _, err = c.conn.ReadCodeLine(381)
-	if err != nil {
-		return
+	var deadline time.Time
+	if c.Timeout > 0 {
+		deadline = time.Now().Add(c.Timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	netconn := c.netconn
+	if err := netconn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if ctx.Done() == nil {
+		return func() {}, nil
 	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			// unblocks the reads and writes in progress
+			netconn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}, nil
+}
 
-	err = c.conn.PrintfLine("authinfo pass %s", pass)
+// ctxErr reports the end of ctx instead of the I/O error it caused.
+func (c *NNTPClient) ctxErr(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// the deadline may hit the connection before ctx notices
+	if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// Authenticate against an NNTP server using authinfo user/pass.
+func (c *NNTPClient) Authenticate(ctx context.Context, user, pass string) (msg string, err error) {
+	_, _, err = c.Command(ctx, "authinfo user "+user, 381)
 	if err != nil {
 		return
 	}
 
-	_, msg, err = c.conn.ReadCodeLine(281)
+	_, msg, err = c.Command(ctx, "authinfo pass "+pass, 281)
 	return
 }
 
 // Command sends a low-level command and get a response.
-func (c *NNTPClient) Command(cmd string, expectCode int) (int, string, error) {
-	err := c.conn.PrintfLine(cmd)
+func (c *NNTPClient) Command(ctx context.Context, cmd string, expectCode int) (int, string, error) {
+	end, err := c.begin(ctx)
 	if err != nil {
 		return 0, "", err
 	}
-	return c.conn.ReadCodeLine(expectCode)
+	defer end()
+
+	err = c.conn.PrintfLine("%s", cmd)
+	if err != nil {
+		return 0, "", c.ctxErr(ctx, err)
+	}
+	code, msg, err := c.conn.ReadCodeLine(expectCode)
+	return code, msg, c.ctxErr(ctx, err)
 }
 
 // asLines issues a command and returns the response's data block as lines.
-func (c *NNTPClient) asLines(cmd string, expectCode int) ([]string, error) {
-	_, _, err := c.Command(cmd, expectCode)
+func (c *NNTPClient) asLines(ctx context.Context, cmd string, expectCode int) ([]string, error) {
+	_, _, err := c.Command(ctx, cmd, expectCode)
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := c.begin(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return c.conn.ReadDotLines()
+	defer end()
+
+	lines, err := c.conn.ReadDotLines()
+	return lines, c.ctxErr(ctx, err)
 }
 
 // capabilities retrieves a list of supported capabilities.
-func (c *NNTPClient) Capabilities() ([]string, error) {
-	caps, err := c.asLines("CAPABILITIES", 101)
+func (c *NNTPClient) Capabilities(ctx context.Context) ([]string, error) {
+	caps, err := c.asLines(ctx, "CAPABILITIES", 101)
 	if err != nil {
 		return nil, err
 	}
@@ -137,18 +215,13 @@ func (c *NNTPClient) HasCapabilityArgument(capability, argument string) (bool, e
 }
 
 // List groups
-func (c *NNTPClient) List(sub string) (rv []Group, err error) {
+func (c *NNTPClient) List(ctx context.Context, sub string) (rv []Group, err error) {
 	cmd := "LIST"
 	if sub != "" {
 		cmd = "LIST " + sub
 	}
-	_, _, err = c.Command(cmd, 215)
-	if err != nil {
-		return
-	}
-
 	var groupLines []string
-	groupLines, err = c.conn.ReadDotLines()
+	groupLines, err = c.asLines(ctx, cmd, 215)
 	if err != nil {
 		return
 	}
@@ -170,8 +243,8 @@ func (c *NNTPClient) List(sub string) (rv []Group, err error) {
 }
 
 // ListOverviewFmt performs a LIST OVERVIEW.FMT query.
-func (c *NNTPClient) ListOverviewFmt() ([]string, error) {
-	fields, err := c.asLines("LIST OVERVIEW.FMT", 215)
+func (c *NNTPClient) ListOverviewFmt(ctx context.Context) ([]string, error) {
+	fields, err := c.asLines(ctx, "LIST OVERVIEW.FMT", 215)
 	if err != nil {
 		return nil, err
 	}
@@ -179,18 +252,45 @@ func (c *NNTPClient) ListOverviewFmt() ([]string, error) {
 }
 
 // Over returns a list of raw overview lines with tab-separated fields.
-func (c *NNTPClient) Over(specifier string) ([]string, error) {
-	lines, err := c.asLines("OVER "+specifier, 224)
+func (c *NNTPClient) Over(ctx context.Context, specifier string) ([]string, error) {
+	lines, err := c.asLines(ctx, "OVER "+specifier, 224)
 	if err != nil {
 		return nil, err
 	}
 	return lines, nil
 }
 
+// ParseOverview parses a raw overview line in the default LIST OVERVIEW.FMT
+// order. Unparseable dates, sizes and line counts are left empty.
+func ParseOverview(line string) (Overview, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) < 8 {
+		return Overview{}, errors.New("Don't know how to parse overview: " + line)
+	}
+
+	num, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return Overview{}, err
+	}
+
+	ov := Overview{
+		Number:     num,
+		Subject:    fields[1],
+		From:       fields[2],
+		MessageID:  strings.TrimSpace(fields[4]),
+		References: fields[5],
+	}
+	ov.Date, _ = mail.ParseDate(fields[3])
+	ov.Bytes, _ = strconv.Atoi(fields[6])
+	ov.Lines, _ = strconv.Atoi(fields[7])
+
+	return ov, nil
+}
+
 // Group select a group.
-func (c *NNTPClient) Group(name string) (rv Group, err error) {
+func (c *NNTPClient) Group(ctx context.Context, name string) (rv Group, err error) {
 	var msg string
-	_, msg, err = c.Command("GROUP "+name, 211)
+	_, msg, err = c.Command(ctx, "GROUP "+name, 211)
 	if err != nil {
 		return
 	}
@@ -221,8 +321,11 @@ func (c *NNTPClient) Group(name string) (rv Group, err error) {
 	return
 }
 
-func (c *NNTPClient) articleish(expected int) (int64, string, io.Reader, error) {
-	_, msg, err := c.conn.ReadCodeLine(expected)
+// articleish issues an ARTICLE, HEAD or BODY command. The returned reader
+// has to be read before the next command, the deadline of the command
+// applies to it.
+func (c *NNTPClient) articleish(ctx context.Context, cmd string, expected int) (int64, string, io.Reader, error) {
+	_, msg, err := c.Command(ctx, cmd, expected)
 	if err != nil {
 		return 0, "", nil, err
 	}
@@ -235,30 +338,69 @@ func (c *NNTPClient) articleish(expected int) (int64, string, io.Reader, error)
 }
 
 // Article grabs an article
-func (c *NNTPClient) Article(specifier string) (int64, string, io.Reader, error) {
-	err := c.conn.PrintfLine("ARTICLE %s", specifier)
-	if err != nil {
-		return 0, "", nil, err
-	}
-	return c.articleish(220)
+func (c *NNTPClient) Article(ctx context.Context, specifier string) (int64, string, io.Reader, error) {
+	return c.articleish(ctx, "ARTICLE "+specifier, 220)
 }
 
 // Head gets the headers of an article
-func (c *NNTPClient) Head(specifier string) (int64, string, io.Reader, error) {
-	err := c.conn.PrintfLine("HEAD %s", specifier)
+func (c *NNTPClient) Head(ctx context.Context, specifier string) (int64, string, io.Reader, error) {
+	return c.articleish(ctx, "HEAD "+specifier, 221)
+}
+
+// Body gets the body of an article
+func (c *NNTPClient) Body(ctx context.Context, specifier string) (int64, string, io.Reader, error) {
+	return c.articleish(ctx, "BODY "+specifier, 222)
+}
+
+// Post sends an article, r holds its headers and body separated by an
+// empty line.
+func (c *NNTPClient) Post(ctx context.Context, r io.Reader) error {
+	_, _, err := c.Command(ctx, "POST", 340)
 	if err != nil {
-		return 0, "", nil, err
+		return err
+	}
+
+	end, err := c.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	w := c.conn.DotWriter()
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return c.ctxErr(ctx, err)
 	}
-	return c.articleish(221)
+	if err := w.Close(); err != nil {
+		return c.ctxErr(ctx, err)
+	}
+
+	_, _, err = c.conn.ReadCodeLine(240)
+	return c.ctxErr(ctx, err)
 }
 
-// Body gets the body of an article
-func (c *NNTPClient) Body(specifier string) (int64, string, io.Reader, error) {
-	err := c.conn.PrintfLine("BODY %s", specifier)
+// StartTLS upgrades the connection to TLS, see RFC 4642. The
+// capabilities have to be asked for again afterwards.
+func (c *NNTPClient) StartTLS(ctx context.Context, config *tls.Config) error {
+	if c.tls {
+		return errors.New("TLS is already in use")
+	}
+
+	_, _, err := c.Command(ctx, "STARTTLS", 382)
 	if err != nil {
-		return 0, "", nil, err
+		return err
+	}
+
+	conn := tls.Client(c.netconn, config)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return err
 	}
-	return c.articleish(222)
+
+	c.netconn = conn
+	c.conn = textproto.NewConn(conn)
+	c.tls = true
+	c.capabilities = nil
+	return nil
 }
 
 // HasTLS checks whether tls supported.
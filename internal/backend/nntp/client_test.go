@@ -0,0 +1,136 @@
+package nntp
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificate creates a self-signed certificate for localhost.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestStartTLS(t *testing.T) {
+	cert, pool := testCertificate(t)
+	client, server := net.Pipe()
+	defer client.Close()
+
+	errc := make(chan string, 1)
+	go func() {
+		defer server.Close()
+		server.Write([]byte("200 ready\r\n"))
+		r := bufio.NewReader(server)
+		if line, _ := r.ReadString('\n'); line != "STARTTLS\r\n" {
+			errc <- "unexpected command: " + line
+			return
+		}
+		server.Write([]byte("382 go ahead\r\n"))
+
+		conn := tls.Server(server, &tls.Config{Certificates: []tls.Certificate{cert}})
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			errc <- err.Error()
+			return
+		}
+		conn.Write([]byte("211 1 1 1 " + strings.Fields(line)[1] + "\r\n"))
+		errc <- ""
+	}()
+
+	ctx := context.Background()
+	c, err := NewConnClient(ctx, client)
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	if err := c.StartTLS(ctx, &tls.Config{ServerName: "localhost", RootCAs: pool}); err != nil {
+		t.Fatalf("Error starting TLS: %v", err)
+	}
+	if !c.HasTLS() {
+		t.Errorf("TLS not reported")
+	}
+
+	group, err := c.Group(ctx, "test.group")
+	if err != nil || group.Name != "test.group" {
+		t.Errorf("Unexpected group %+v: %v", group, err)
+	}
+	if msg := <-errc; msg != "" {
+		t.Errorf("Server: %s", msg)
+	}
+
+	if err := c.StartTLS(ctx, &tls.Config{}); err == nil {
+		t.Errorf("STARTTLS accepted twice")
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// the server greets and then stalls
+	go func() {
+		server.Write([]byte("200 ready\r\n"))
+		io.Copy(io.Discard, server)
+	}()
+
+	c, err := NewConnClient(context.Background(), client)
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+
+	c.Timeout = 20 * time.Millisecond
+	_, _, err = c.Command(context.Background(), "DATE", 111)
+	if !isBroken(err) {
+		t.Errorf("Expected timeout, got %v", err)
+	}
+
+	c.Timeout = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, _, err = c.Command(ctx, "DATE", 111)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancellation, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = c.Over(ctx, "1-10")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline, got %v", err)
+	}
+}
@@ -1,10 +1,24 @@
 package nntp
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net/mail"
+	"net/textproto"
 	"newsmere/internal/storage"
 	"newsmere/internal/types"
+	"newsmere/internal/wildmat"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"gorm.io/gorm/clause"
 )
@@ -15,25 +29,47 @@ func New(config json.RawMessage) (*Backend, error) {
 	return backend, err
 }
 
-func (Backend) Type() string {
+func (*Backend) Type() string {
 	return Type
 }
 
 func (b *Backend) Start() error {
 	fmt.Printf("[Backend] %s-%s starting\n", b.Type(), b.Name)
+	return nil
+}
+
+// Sync updates the subscriptions and fetches new articles.
+func (b *Backend) Sync() error {
 	err := b.syncSubs()
 	fmt.Printf("[Backend] %s-%s sync subscriptions finished\n",
 		b.Type(), b.Name)
+	if err != nil {
+		return err
+	}
+
+	err = b.syncArticles()
+	fmt.Printf("[Backend] %s-%s sync articles finished\n",
+		b.Type(), b.Name)
 	return err
 }
 
+// Stop closes the connections, a sync in progress fails on its next
+// command.
 func (b *Backend) Stop() error {
-	if err := b.client.Close(); err != nil {
-		return err
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stopped = true
+
+	var firstErr error
+	for _, p := range b.pools {
+		if err := p.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-	b.client = nil
+	b.pools = nil
 
-	return nil
+	return firstErr
 }
 
 func (b *Backend) Restart() error {
@@ -41,6 +77,10 @@ func (b *Backend) Restart() error {
 		return err
 	}
 
+	b.mu.Lock()
+	b.stopped = false
+	b.mu.Unlock()
+
 	if err := b.Start(); err != nil {
 		return err
 	}
@@ -48,101 +88,699 @@ func (b *Backend) Restart() error {
 	return nil
 }
 
+// Status is the status of the main server, degraded if it is down but
+// another server is not.
 func (b *Backend) Status() string {
-	if b.client == nil {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.pools) == 0 {
 		return types.StatusDown
 	}
 
-	return types.StatusUp
+	status := b.pools[0].status()
+	if status == types.StatusUp {
+		return status
+	}
+	for _, p := range b.pools[1:] {
+		if p.status() != types.StatusDown {
+			return types.StatusDegraded
+		}
+	}
+	return status
+}
+
+// conns returns the connection pools of the servers by priority, they are
+// created on first use.
+func (b *Backend) conns() ([]*pool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.stopped {
+		return nil, errPoolClosed
+	}
+	if b.pools == nil {
+		for i, server := range b.servers() {
+			i, server := i, server
+			dial := func(ctx context.Context) (*NNTPClient, error) {
+				client, err := b.dial(ctx, server)
+				if err != nil {
+					return nil, err
+				}
+				client.server = i
+				return client, nil
+			}
+			b.pools = append(b.pools,
+				newPool(server.addr(), server.maxConns(), dial))
+		}
+	}
+	if len(b.pools) == 0 {
+		return nil, errNoServer
+	}
+	return b.pools, nil
+}
+
+// servers returns the configured servers by priority.
+func (b *Backend) servers() []ServerConfig {
+	var servers []ServerConfig
+	if b.Server != "" {
+		servers = append(servers, b.ServerConfig)
+	}
+	servers = append(servers, b.Servers...)
+	sort.SliceStable(servers, func(i, j int) bool {
+		return servers[i].Priority < servers[j].Priority
+	})
+	return servers
 }
 
-func (b *Backend) ensureClient() error {
-	if b.client != nil {
-		return nil
+func (b *Backend) timeout() time.Duration {
+	if b.Timeout <= 0 {
+		return defaultTimeout * time.Second
 	}
+	return time.Duration(b.Timeout) * time.Second
+}
 
-	addr := fmt.Sprintf("%s:%d", b.Server, b.Port)
-	if b.Port == 0 {
-		addr = b.Server + ":119"
+// maxConns is the number of connections of the main server.
+func (b *Backend) maxConns() int {
+	servers := b.servers()
+	if len(servers) == 0 {
+		return defaultMaxConns
 	}
+	return servers[0].maxConns()
+}
 
-	var err error
-	b.client, err = NewClient("tcp", addr)
+// withClient runs fn on a pooled connection, ctx ends when the backend is
+// stopped. The servers are tried by priority, the next one is used if a
+// server is unreachable or misses an article. If the connection breaks and
+// retry is set, fn is run again on a new connection after a backoff; fn
+// has to be safe to repeat then.
+func (b *Backend) withClient(retry bool,
+	fn func(context.Context, *NNTPClient) error) error {
+	return b.withServers(0, retry, fn)
+}
+
+// withServers is withClient limited to the servers from the first-th on.
+func (b *Backend) withServers(first int, retry bool,
+	fn func(context.Context, *NNTPClient) error) error {
+	conns, err := b.conns()
 	if err != nil {
 		return err
 	}
+	if first >= len(conns) {
+		return errNoServer
+	}
+	conns = conns[first:]
 
-	if b.User != "" {
-		_, err := b.client.Authenticate(b.User, b.Pass)
-		if err != nil {
+	for attempt := 1; ; attempt++ {
+		for i, p := range conns {
+			// servers backing off are skipped while there are others
+			if !p.available() && i < len(conns)-1 {
+				continue
+			}
+
+			var client *NNTPClient
+			client, err = p.get()
+			if errors.Is(err, errPoolClosed) {
+				return err
+			}
+			if err != nil {
+				fmt.Printf("[Backend] %s-%s connecting to %s failed: %v\n",
+					b.Type(), b.Name, p.name, err)
+				continue
+			}
+
+			err = fn(p.ctx, client)
+			p.put(client, err)
+			if isMissing(err) {
+				continue
+			}
+			if !retry || !isBroken(err) {
+				return err
+			}
+		}
+
+		if !retry || isMissing(err) || attempt >= maxAttempts {
+			return err
+		}
+
+		fmt.Printf("[Backend] %s-%s connection failed, retrying: %v\n",
+			b.Type(), b.Name, err)
+		if !conns[0].wait(attempt) {
 			return err
 		}
 	}
+}
+
+// addr returns the address of the server.
+func (s *ServerConfig) addr() string {
+	port := s.Port
+	if port == 0 && s.TLS {
+		port = 563
+	} else if port == 0 {
+		port = 119
+	}
+	return fmt.Sprintf("%s:%d", s.Server, port)
+}
+
+func (s *ServerConfig) maxConns() int {
+	if s.MaxConns <= 0 {
+		return defaultMaxConns
+	}
+	return s.MaxConns
+}
+
+// dial opens an authenticated connection to a server.
+func (b *Backend) dial(ctx context.Context, s ServerConfig) (*NNTPClient, error) {
+	addr := s.addr()
+
+	var config *tls.Config
+	if s.TLS || s.StartTLS {
+		var err error
+		if config, err = s.tlsConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.timeout())
+	defer cancel()
+
+	var client *NNTPClient
+	if s.TLS {
+		dialer := &tls.Dialer{Config: config}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		client, err = NewConnClient(ctx, conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	} else {
+		var err error
+		client, err = NewClient(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	client.Timeout = b.timeout()
 
+	if s.StartTLS && !s.TLS {
+		if err := client.StartTLS(ctx, config); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	if s.User != "" {
+		_, err := client.Authenticate(ctx, s.User, s.Pass)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+// tlsConfig builds the TLS configuration from the certificate options.
+func (s *ServerConfig) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         s.Server,
+		InsecureSkipVerify: s.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if s.CAFile != "" {
+		pem, err := os.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", s.CAFile)
+		}
+	}
+
+	if s.CertFile != "" || s.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// Post forwards an article to the server.
+func (b *Backend) Post(article io.Reader) error {
+	// the server may have taken the article before the connection
+	// broke, so it is not posted again
+	err := b.withClient(false, func(ctx context.Context, client *NNTPClient) error {
+		return client.Post(ctx, article)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("[Backend] %s-%s posted article\n", b.Type(), b.Name)
 	return nil
 }
 
+// syncSubs lists the remote groups and serves the enabled subscriptions
+// selected by the configuration. The groups are listed on every sync, so
+// that groups created on the server or matched by a changed groups
+// wildmat are picked up.
 func (b *Backend) syncSubs() error {
-	if err := b.ensureClient(); err != nil {
+	db := storage.GetDb()
+
+	if err := b.withClient(true, b.listSubs); err != nil {
 		return err
 	}
 
-	db := storage.GetDb()
+	var subs []storage.Subscription
+	result := db.Where("source = ? AND enabled = ?", b.Name, true).
+		Order("name").Find(&subs)
+	if result.Error != nil {
+		return result.Error
+	}
 
-	// check subscriptions exist
-	var count int64
-	db.Model(&storage.Subscription{}).Where("source = ?", b.Name).Count(&count)
+	return b.serveGroups(b.selectSubs(subs))
+}
+
+// listSubs subscribes to the remote groups matching the groups wildmat.
+func (b *Backend) listSubs(ctx context.Context, client *NNTPClient) error {
+	match, list := wildmat.Parse("*"), ""
+	if b.Groups != "" {
+		match, list = wildmat.Parse(b.Groups), "ACTIVE "+b.Groups
+	}
+
+	// servers may ignore the wildmat, the groups are filtered anyway
+	groups, err := client.List(ctx, list)
+	if err != nil {
+		return err
+	}
 
 	var subs []storage.Subscription
-	if count == 0 {
-		groups, err := b.client.List("")
-		if err != nil {
-			return err
+	for _, g := range groups {
+		if !match.Match(g.Name) {
+			continue
 		}
+		subs = append(subs, storage.Subscription{
+			Name:        g.Name,
+			Description: g.Name,
+			High:        int(g.High),
+			Low:         int(g.Low),
+			Source:      b.Name,
+		})
+	}
+	if len(subs) == 0 {
+		return nil
+	}
 
-		for _, g := range groups {
-			subs = append(subs, storage.Subscription{
-				Name:        g.Name,
-				Description: g.Name,
-				High:        int(g.High),
-				Low:         int(g.Low),
-				Source:      b.Name,
-			})
-		}
+	return storage.GetDb().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}, {Name: "source"}},
+		DoUpdates: clause.AssignmentColumns([]string{"high", "low"}),
+	}).CreateInBatches(&subs, 500).Error
+}
 
-		result := db.Clauses(clause.OnConflict{
-			Columns:   []clause.Column{{Name: "name"}, {Name: "source"}},
-			DoUpdates: clause.AssignmentColumns([]string{"high", "low"}),
-		}).Create(&subs)
+// selectSubs filters the subscriptions by the groups and exclude wildmats
+// and caps them at MaxGroups.
+func (b *Backend) selectSubs(subs []storage.Subscription) []storage.Subscription {
+	include := wildmat.Parse("*")
+	if b.Groups != "" {
+		include = wildmat.Parse(b.Groups)
+	}
+	exclude := wildmat.Parse(b.Exclude)
 
-		if result.Error != nil {
-			return result.Error
+	var selected []storage.Subscription
+	for _, s := range subs {
+		if b.MaxGroups > 0 && len(selected) >= b.MaxGroups {
+			break
 		}
-
-		subs = subs[:10]
-	} else {
-		result := db.Where("source = ?", b.Name).Limit(10).Find(&subs)
-		if result.Error != nil {
-			return result.Error
+		if include.Match(s.Name) && (b.Exclude == "" || !exclude.Match(s.Name)) {
+			selected = append(selected, s)
 		}
 	}
+	return selected
+}
+
+// serveGroups creates the groups of the subscriptions and disables the other
+// groups of the backend.
+func (b *Backend) serveGroups(subs []storage.Subscription) error {
+	db := storage.GetDb()
+
+	if len(subs) == 0 {
+		return db.Model(&storage.Group{}).Where("source = ?", b.Name).
+			Update("enabled", false).Error
+	}
 
-	// create groups
 	var groups []storage.Group
+	names := make([]string, 0, len(subs))
 	for _, s := range subs {
 		groups = append(groups, storage.Group{
 			Name:        s.Name,
 			Description: s.Description,
 			Source:      s.Source,
-			Low:         s.Low,
-			High:        s.High,
 		})
+		names = append(names, s.Name)
 	}
 
+	// marks of served groups are local, see storage.SaveArticle
 	result := db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "name"}, {Name: "Source"}},
-		DoUpdates: clause.AssignmentColumns([]string{"high", "low"}),
-	}).Create(&groups)
+		DoNothing: true,
+	}).CreateInBatches(&groups, 500)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	result = db.Model(&storage.Group{}).
+		Where("source = ? AND name IN ?", b.Name, names).
+		Update("enabled", true)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return db.Model(&storage.Group{}).
+		Where("source = ? AND name NOT IN ?", b.Name, names).
+		Update("enabled", false).Error
+}
 
-	return result.Error
+// syncArticles syncs the served groups, as many in parallel as the pool
+// has connections.
+func (b *Backend) syncArticles() error {
+	db := storage.GetDb()
+
+	var groups []storage.Group
+	result := db.Where("source = ? AND enabled = ?", b.Name, true).Find(&groups)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// no more goroutines than connections are running
+	slots := make(chan struct{}, b.maxConns())
+	errc := make(chan error, len(groups))
+	var wg sync.WaitGroup
+	for i := range groups {
+		group := &groups[i]
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			// the progress is saved, syncing again resumes
+			err := b.withClient(true, func(ctx context.Context, client *NNTPClient) error {
+				return b.syncGroup(ctx, client, group)
+			})
+			if err != nil {
+				errc <- fmt.Errorf("group %s: %w", group.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errc)
+
+	var errs []string
+	for err := range errc {
+		fmt.Printf("[Backend] %s-%s %v\n", b.Type(), b.Name, err)
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (b *Backend) syncGroup(ctx context.Context, client *NNTPClient,
+	group *storage.Group) error {
+	db := storage.GetDb()
+
+	var sub storage.Subscription
+	result := db.Where("name = ? AND source = ?", group.Name, b.Name).First(&sub)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	remote, err := client.Group(ctx, group.Name)
+	if err != nil {
+		return err
+	}
+
+	// servers number the articles on their own
+	server := b.serverName(client)
+	fetched, err := watermark(&sub, server)
+	if err != nil {
+		return err
+	}
+
+	sub.High = int(remote.High)
+	sub.Low = int(remote.Low)
+	sub.Fetched = int(nextFetch(remote, int64(fetched)) - 1)
+	if err := saveProgress(&sub, server); err != nil {
+		return err
+	}
+
+	if remote.Count == 0 || remote.High < remote.Low {
+		return nil
+	}
+
+	for from := int64(sub.Fetched) + 1; from <= remote.High; from += overBatch {
+		to := from + overBatch - 1
+		if to > remote.High {
+			to = remote.High
+		}
+
+		lines, err := client.Over(ctx, fmt.Sprintf("%d-%d", from, to))
+		if err != nil {
+			return err
+		}
+
+		for _, l := range lines {
+			ov, err := ParseOverview(l)
+			if err != nil {
+				log.Printf("[Backend] %s-%s skip overview: %v", b.Type(), b.Name, err)
+				continue
+			}
+
+			err = b.fetchArticle(ctx, client, group, ov)
+			if isMissing(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			sub.Fetched = int(ov.Number)
+		}
+
+		// numbers without an overview line don't exist on the server
+		sub.Fetched = int(to)
+		if err := saveProgress(&sub, server); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// nextFetch returns the first remote article number to fetch. The server
+// may have expired articles past our mark or renumbered the group, so that
+// the mark lies beyond its high water mark, in both cases fetching starts
+// at the remote low mark again. Already stored articles are skipped by
+// their message-id.
+func nextFetch(remote Group, fetched int64) int64 {
+	if fetched > remote.High || fetched < remote.Low-1 {
+		return remote.Low
+	}
+	return fetched + 1
+}
+
+// watermark returns the number of the last article of the subscription
+// fetched from the server.
+func watermark(sub *storage.Subscription, server string) (int, error) {
+	var marks []storage.Watermark
+	err := storage.GetDb().Where("subscription_id = ?", sub.ID).Find(&marks).Error
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range marks {
+		if m.Server == server {
+			return m.Fetched, nil
+		}
+	}
+	if len(marks) == 0 {
+		// synced before the marks were kept per server
+		return sub.Fetched, nil
+	}
+	return 0, nil
+}
+
+// saveProgress saves the marks of the subscription, Fetched as the
+// watermark of the server.
+func saveProgress(sub *storage.Subscription, server string) error {
+	db := storage.GetDb()
+	err := db.Model(sub).Updates(map[string]interface{}{
+		"high":    sub.High,
+		"low":     sub.Low,
+		"fetched": sub.Fetched,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	mark := storage.Watermark{
+		SubscriptionId: sub.ID,
+		Server:         server,
+		Fetched:        sub.Fetched,
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "subscription_id"}, {Name: "server"}},
+		DoUpdates: clause.AssignmentColumns([]string{"fetched", "updated_at"}),
+	}).Create(&mark).Error
+}
+
+// serverName returns the name of the server a connection belongs to.
+func (b *Backend) serverName(client *NNTPClient) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if client.server < len(b.pools) {
+		return b.pools[client.server].name
+	}
+	return ""
+}
+
+// fetchArticle downloads a single article and stores it into the group,
+// articles which are already stored are skipped.
+func (b *Backend) fetchArticle(ctx context.Context, client *NNTPClient,
+	group *storage.Group, ov Overview) error {
+	db := storage.GetDb()
+
+	// articles already stored, e.g. cross-posted to another group, are
+	// only added to this one
+	if ov.MessageID != "" {
+		err := storage.LinkArticle(db, ov.MessageID, []uint{group.ID})
+		if !errors.Is(err, storage.ErrNoSuchArticle) {
+			return err
+		}
+	}
+
+	err := b.storeArticle(ctx, client, fmt.Sprint(ov.Number), group, ov)
+	if !isMissing(err) || ov.MessageID == "" {
+		return err
+	}
+
+	// servers later in priority may still have it, connections are only
+	// taken from those so that no two syncs wait on each other
+	berr := b.withServers(client.server+1, false,
+		func(ctx context.Context, client *NNTPClient) error {
+			return b.storeArticle(ctx, client, ov.MessageID, group, ov)
+		})
+	if berr == errNoServer {
+		return err
+	}
+	return berr
+}
+
+// storeArticle downloads the article given by number or message-id and
+// stores it into the group.
+func (b *Backend) storeArticle(ctx context.Context, client *NNTPClient,
+	specifier string, group *storage.Group, ov Overview) error {
+	_, _, r, err := client.Article(ctx, specifier)
+	if err != nil {
+		return err
+	}
+	// the rest of the article must be consumed before the next command
+	defer io.Copy(io.Discard, r)
+
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return err
+	}
+
+	headers, err := json.Marshal(msg.Header)
+	if err != nil {
+		return err
+	}
+
+	article := storage.Article{
+		MsgID:      ov.MessageID,
+		DocType:    Type,
+		Bytes:      ov.Bytes,
+		Lines:      ov.Lines,
+		Title:      ov.Subject,
+		Author:     ov.From,
+		PostedAt:   ov.Date,
+		References: ov.References,
+		Headers:    headers,
+		Server:     b.serverName(client),
+	}
+	if article.MsgID == "" {
+		article.MsgID = msg.Header.Get("Message-Id")
+	}
+	if article.PostedAt.IsZero() {
+		article.PostedAt, _ = msg.Header.Date()
+	}
+
+	groups, err := b.xrefGroups(group, msg.Header.Get("Xref"))
+	if err != nil {
+		return err
+	}
+
+	// read before storing, the transaction mustn't wait for the network
+	body, err := storage.Spool(msg.Body)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	err = storage.SaveArticle(storage.GetDb(), &article, groups, body)
+	if errors.Is(err, storage.ErrArticleExists) {
+		return nil
+	}
+	return err
+}
+
+// xrefGroups returns the group and the other served groups of the source
+// the Xref header lists, a cross-post is stored into all of them at once.
+func (b *Backend) xrefGroups(group *storage.Group, xref string) ([]uint, error) {
+	ids := []uint{group.ID}
+
+	// the first field names the server, the others are group:number
+	fields := strings.Fields(xref)
+	var names []string
+	for i := 1; i < len(fields); i++ {
+		name, _, ok := strings.Cut(fields[i], ":")
+		if ok && name != group.Name {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ids, nil
+	}
+
+	var others []uint
+	err := storage.GetDb().Model(&storage.Group{}).
+		Where("source = ? AND name IN ? AND enabled = ?", b.Name, names, true).
+		Pluck("id", &others).Error
+	if err != nil {
+		return nil, err
+	}
+	return append(ids, others...), nil
+}
+
+// isMissing reports whether err means the article is not available on the
+// server any more.
+func isMissing(err error) bool {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code == 423 || protoErr.Code == 430
+	}
+	return false
 }
@@ -0,0 +1,326 @@
+package nntp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"newsmere/internal/storage"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testArticle is an article of a newsServer.
+type testArticle struct {
+	msgID, subject, from, refs, body string
+	date                             time.Time
+}
+
+func (a testArticle) String() string {
+	return fmt.Sprintf("Message-ID: %s\nSubject: %s\nFrom: %s\nDate: %s\n"+
+		"References: %s\n\n%s", a.msgID, a.subject, a.from,
+		a.date.Format(time.RFC1123Z), a.refs, a.body)
+}
+
+// newsServer fakes a server holding a single group, whose articles are
+// numbered from 1, and listing the other groups as empty. It keeps the
+// commands it got.
+type newsServer struct {
+	group string
+	other []string
+
+	mu       sync.Mutex
+	articles []testArticle
+	commands []string
+}
+
+func (s *newsServer) dial(ctx context.Context) (*NNTPClient, error) {
+	client, server := net.Pipe()
+	go s.serve(textproto.NewConn(server))
+	return NewConnClient(ctx, client)
+}
+
+func (s *newsServer) serve(conn *textproto.Conn) {
+	defer conn.Close()
+
+	conn.PrintfLine("200 ready")
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		articles := s.articles
+		s.mu.Unlock()
+
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch cmd {
+		case "LIST":
+			// the wildmat is left to the client
+			conn.PrintfLine("215 list follows")
+			w := conn.DotWriter()
+			fmt.Fprintf(w, "%s %d 1 y\n", s.group, len(articles))
+			for _, name := range s.other {
+				fmt.Fprintf(w, "%s 0 1 y\n", name)
+			}
+			w.Close()
+		case "GROUP":
+			conn.PrintfLine("211 %d 1 %d %s", len(articles), len(articles), s.group)
+		case "OVER":
+			from, to, _ := strings.Cut(arg, "-")
+			first, _ := strconv.Atoi(from)
+			last, _ := strconv.Atoi(to)
+			conn.PrintfLine("224 overview follows")
+			w := conn.DotWriter()
+			for n := first; n <= last && n <= len(articles); n++ {
+				a := articles[n-1]
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%d\t%d\n", n,
+					a.subject, a.from, a.date.Format(time.RFC1123Z), a.msgID,
+					a.refs, len(a.String()), strings.Count(a.body, "\n"))
+			}
+			w.Close()
+		case "ARTICLE":
+			n, _ := strconv.Atoi(arg)
+			if n < 1 || n > len(articles) {
+				conn.PrintfLine("423 no such article")
+				continue
+			}
+			conn.PrintfLine("220 %d %s", n, articles[n-1].msgID)
+			w := conn.DotWriter()
+			io.WriteString(w, articles[n-1].String())
+			w.Close()
+		default:
+			conn.PrintfLine("500 unknown")
+		}
+	}
+}
+
+// overs returns the ranges asked for by OVER.
+func (s *newsServer) overs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var overs []string
+	for _, c := range s.commands {
+		if strings.HasPrefix(c, "OVER ") {
+			overs = append(overs, strings.TrimPrefix(c, "OVER "))
+		}
+	}
+	return overs
+}
+
+// newSyncBackend opens the storage and returns a backend syncing the group
+// of the server.
+func newSyncBackend(t *testing.T, s *newsServer) *Backend {
+	if err := storage.Open(storage.Config{DSN: ":memory:"}); err != nil {
+		t.Fatalf("Error opening storage: %v", err)
+	}
+	t.Cleanup(func() { storage.Close() })
+
+	b := &Backend{Name: "news"}
+	b.pools = []*pool{newPool("news.example:119", 1, s.dial)}
+	t.Cleanup(func() { b.Stop() })
+
+	db := storage.GetDb()
+	sub := storage.Subscription{Name: s.group, Source: b.Name}
+	group := storage.Group{Name: s.group, Source: b.Name}
+	if err := db.Create(&sub).Error; err != nil {
+		t.Fatalf("Error creating subscription: %v", err)
+	}
+	if err := db.Create(&group).Error; err != nil {
+		t.Fatalf("Error creating group: %v", err)
+	}
+	return b
+}
+
+func TestSelectSubs(t *testing.T) {
+	var subs []storage.Subscription
+	for _, name := range []string{"alt.test", "comp.lang.c", "comp.lang.go", "comp.os.linux"} {
+		subs = append(subs, storage.Subscription{Name: name})
+	}
+
+	for _, tc := range []struct {
+		backend  *Backend
+		selected string
+	}{
+		{&Backend{}, "alt.test comp.lang.c comp.lang.go comp.os.linux"},
+		{&Backend{Groups: "comp.*"}, "comp.lang.c comp.lang.go comp.os.linux"},
+		{&Backend{Groups: "comp.*", Exclude: "*.c"}, "comp.lang.go comp.os.linux"},
+		{&Backend{Exclude: "comp.lang.*,!comp.lang.go"}, "alt.test comp.lang.go comp.os.linux"},
+		{&Backend{Groups: "comp.*", MaxGroups: 2}, "comp.lang.c comp.lang.go"},
+		{&Backend{Groups: "sci.*"}, ""},
+	} {
+		var names []string
+		for _, s := range tc.backend.selectSubs(subs) {
+			names = append(names, s.Name)
+		}
+		if got := strings.Join(names, " "); got != tc.selected {
+			t.Errorf("%q/%q/%d: selected %q, expected %q", tc.backend.Groups,
+				tc.backend.Exclude, tc.backend.MaxGroups, got, tc.selected)
+		}
+	}
+}
+
+func TestNextFetch(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		low     int64
+		high    int64
+		fetched int64
+		want    int64
+	}{
+		{"never fetched", 1, 10, 0, 1},
+		{"resume", 1, 10, 4, 5},
+		{"up to date", 1, 10, 10, 11},
+		{"expired past the mark", 20, 30, 10, 20},
+		{"mark at the low mark", 20, 30, 19, 20},
+		{"renumbered below the mark", 1, 5, 10, 1},
+		{"empty group", 11, 10, 10, 11},
+	} {
+		got := nextFetch(Group{Low: tc.low, High: tc.high}, tc.fetched)
+		if got != tc.want {
+			t.Errorf("%s: Expected %d, got %d", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestSyncResumes(t *testing.T) {
+	date := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := &newsServer{group: "test.group"}
+	for i := 1; i <= 2; i++ {
+		s.articles = append(s.articles, testArticle{
+			msgID: fmt.Sprintf("<%d@test>", i), subject: "hello",
+			from: "a@test", body: "body\n", date: date,
+		})
+	}
+	b := newSyncBackend(t, s)
+
+	if err := b.syncArticles(); err != nil {
+		t.Fatalf("Error syncing: %v", err)
+	}
+
+	s.mu.Lock()
+	s.articles = append(s.articles, testArticle{
+		msgID: "<3@test>", subject: "again", from: "a@test",
+		body: "body\n", date: date,
+	})
+	s.mu.Unlock()
+
+	if err := b.syncArticles(); err != nil {
+		t.Fatalf("Error syncing again: %v", err)
+	}
+
+	// the second sync only asks for the article above the mark
+	if overs := s.overs(); len(overs) != 2 || overs[0] != "1-2" || overs[1] != "3-3" {
+		t.Errorf("Unexpected OVER ranges: %v", overs)
+	}
+
+	var marks []storage.Watermark
+	storage.GetDb().Find(&marks)
+	if len(marks) != 1 || marks[0].Server != "news.example:119" || marks[0].Fetched != 3 {
+		t.Errorf("Unexpected watermarks: %+v", marks)
+	}
+}
+
+func TestSyncStoresArticles(t *testing.T) {
+	article := testArticle{
+		msgID:   "<1@test>",
+		subject: "hello",
+		from:    "Alice <a@test>",
+		refs:    "<0@test>",
+		body:    strings.Repeat("line\n", storage.ChunkSize/5+10),
+		date:    time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	s := &newsServer{group: "test.group", articles: []testArticle{article}}
+	b := newSyncBackend(t, s)
+
+	if err := b.syncArticles(); err != nil {
+		t.Fatalf("Error syncing: %v", err)
+	}
+
+	db := storage.GetDb()
+	var stored []storage.Article
+	db.Preload("Groups").Preload("Chunks").Find(&stored)
+	if len(stored) != 1 {
+		t.Fatalf("Expected 1 article, got %d", len(stored))
+	}
+	a := stored[0]
+
+	// the overview fields
+	if a.MsgID != article.msgID || a.Title != article.subject ||
+		a.Author != article.from || a.References != article.refs ||
+		!a.PostedAt.Equal(article.date) || a.DocType != Type ||
+		a.Bytes != len(article.String()) || a.Lines != storage.ChunkSize/5+10 ||
+		a.Server != "news.example:119" {
+		t.Errorf("Unexpected article: %+v", a)
+	}
+	if len(a.Groups) != 1 || a.Groups[0].Number != 1 {
+		t.Errorf("Unexpected groups: %+v", a.Groups)
+	}
+
+	var headers map[string][]string
+	if err := json.Unmarshal(a.Headers, &headers); err != nil {
+		t.Fatalf("Error decoding headers: %v", err)
+	}
+	if headers["Subject"][0] != article.subject || headers["Message-Id"][0] != article.msgID {
+		t.Errorf("Unexpected headers: %v", headers)
+	}
+
+	// the body spans two chunks
+	if len(a.Chunks) != 2 {
+		t.Errorf("Expected 2 chunks, got %d", len(a.Chunks))
+	}
+	body, err := io.ReadAll(storage.OpenBody(db, a.ID))
+	if err != nil || string(body) != article.body {
+		t.Errorf("Unexpected body of %d bytes: %v", len(body), err)
+	}
+}
+
+func TestSyncSubsRelists(t *testing.T) {
+	s := &newsServer{group: "test.group", other: []string{"other.group"}}
+	b := newSyncBackend(t, s)
+	b.Groups = "test.*"
+
+	served := func() []string {
+		var names []string
+		storage.GetDb().Model(&storage.Group{}).
+			Where("source = ? AND enabled = ?", b.Name, true).
+			Order("name").Pluck("name", &names)
+		return names
+	}
+
+	if err := b.syncSubs(); err != nil {
+		t.Fatalf("Error syncing subscriptions: %v", err)
+	}
+	if names := served(); len(names) != 1 || names[0] != "test.group" {
+		t.Errorf("Unexpected groups: %v", names)
+	}
+
+	// groups matched by a changed wildmat are subscribed
+	b.Groups = "test.*,other.*"
+	if err := b.syncSubs(); err != nil {
+		t.Fatalf("Error syncing subscriptions: %v", err)
+	}
+	if names := served(); len(names) != 2 || names[0] != "other.group" {
+		t.Errorf("Unexpected groups: %v", names)
+	}
+
+	var lists []string
+	s.mu.Lock()
+	for _, c := range s.commands {
+		if strings.HasPrefix(c, "LIST") {
+			lists = append(lists, c)
+		}
+	}
+	s.mu.Unlock()
+	if len(lists) != 2 || lists[1] != "LIST ACTIVE test.*,other.*" {
+		t.Errorf("Unexpected LIST commands: %v", lists)
+	}
+}
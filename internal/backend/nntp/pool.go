@@ -0,0 +1,280 @@
+package nntp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/textproto"
+	"newsmere/internal/types"
+	"sync"
+	"time"
+)
+
+const (
+	// idleCheck is the time after which an idle connection is checked
+	// before it is handed out again.
+	idleCheck = 30 * time.Second
+	// backoffJitter is the fraction by which a wait is varied.
+	backoffJitter = 0.2
+)
+
+// minBackoff and maxBackoff bound the wait before retrying on a new
+// connection, the wait doubles with each failure in a row.
+var (
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+var (
+	errPoolClosed = errors.New("connection pool is closed")
+	errNoServer   = errors.New("no server configured")
+)
+
+// pool bounds the connections to a server. Connections are dialed, and so
+// authenticated, on demand and reused while they are healthy.
+type pool struct {
+	// name identifies the server
+	name string
+	dial func(context.Context) (*NNTPClient, error)
+	// slots holds a token for each connection in use
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []idleClient
+	busy   map[*NNTPClient]bool
+	closed bool
+	// ctx is canceled when the pool is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+	// failures counts the connections broken in a row, the server is
+	// avoided until retryAt then
+	failures int
+	retryAt  time.Time
+}
+
+type idleClient struct {
+	client *NNTPClient
+	since  time.Time
+}
+
+func newPool(name string, max int,
+	dial func(context.Context) (*NNTPClient, error)) *pool {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &pool{
+		name:   name,
+		dial:   dial,
+		slots:  make(chan struct{}, max),
+		busy:   make(map[*NNTPClient]bool),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// get returns a connection, waiting for one if all are in use. It has to
+// be given back with put.
+func (p *pool) get() (*NNTPClient, error) {
+	p.slots <- struct{}{}
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			<-p.slots
+			return nil, errPoolClosed
+		}
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		idle := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.busy[idle.client] = true
+		p.mu.Unlock()
+
+		if time.Since(idle.since) < idleCheck || healthy(p.ctx, idle.client) {
+			return idle.client, nil
+		}
+		// the slot taken above is kept for the next one
+		p.drop(idle.client)
+	}
+
+	client, err := p.dial(p.ctx)
+	if err != nil {
+		p.fail()
+		<-p.slots
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		client.Close()
+		<-p.slots
+		return nil, errPoolClosed
+	}
+	p.busy[client] = true
+	return client, nil
+}
+
+// put gives back a connection, err is the outcome of its last use. The
+// connection is only reused if it is still in step with the server.
+func (p *pool) put(client *NNTPClient, err error) {
+	if isBroken(err) {
+		p.fail()
+		p.discard(client)
+		return
+	}
+
+	// some other failure, e.g. of the storage, may have left a reply
+	// unread
+	var protoErr *textproto.Error
+	if err != nil && !errors.As(err, &protoErr) {
+		p.discard(client)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failures = 0
+	p.retryAt = time.Time{}
+	delete(p.busy, client)
+	if p.closed {
+		client.Close()
+	} else {
+		p.idle = append(p.idle, idleClient{client: client, since: time.Now()})
+	}
+	<-p.slots
+}
+
+// fail counts a failed connection.
+func (p *pool) fail() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failures++
+	p.retryAt = time.Now().Add(backoff(p.failures))
+}
+
+// available reports whether the server is worth trying, it is not while
+// backing off after failures.
+func (p *pool) available() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return !p.closed && !time.Now().Before(p.retryAt)
+}
+
+// discard closes a connection in use and gives back its slot.
+func (p *pool) discard(client *NNTPClient) {
+	p.drop(client)
+	<-p.slots
+}
+
+// drop closes a connection without giving back a slot.
+func (p *pool) drop(client *NNTPClient) {
+	client.Close()
+
+	p.mu.Lock()
+	delete(p.busy, client)
+	p.mu.Unlock()
+}
+
+// close closes all connections, the commands and dials in progress are
+// interrupted.
+func (p *pool) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.cancel()
+	p.closed = true
+
+	var firstErr error
+	for _, idle := range p.idle {
+		if err := idle.client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	p.idle = nil
+
+	for client := range p.busy {
+		if err := client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// open returns the number of open connections.
+func (p *pool) open() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.idle) + len(p.busy)
+}
+
+// status reports the state of the connections.
+func (p *pool) status() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	open := len(p.idle) + len(p.busy)
+	switch {
+	case open > 0 && p.failures == 0:
+		return types.StatusUp
+	case open > 0:
+		return types.StatusDegraded
+	default:
+		return types.StatusDown
+	}
+}
+
+// wait sleeps for the backoff after the given number of failures, it
+// returns false if the pool was closed meanwhile.
+func (p *pool) wait(failures int) bool {
+	select {
+	case <-time.After(backoff(failures)):
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+// backoff doubles the wait with each failure, varied randomly so that
+// connections don't retry in lockstep.
+func backoff(failures int) time.Duration {
+	d := minBackoff
+	for i := 1; i < failures && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d + time.Duration(float64(d)*backoffJitter*(2*rand.Float64()-1))
+}
+
+// isBroken reports whether err means the connection is unusable: it was
+// closed or timed out, or the server discontinued the service.
+func isBroken(err error) bool {
+	var protoErr *textproto.Error
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return false
+	case errors.As(err, &protoErr):
+		return protoErr.Code == 400
+	case errors.As(err, &netErr):
+		return true
+	default:
+		return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+			errors.Is(err, io.ErrClosedPipe) || errors.Is(err, net.ErrClosed)
+	}
+}
+
+// healthy checks that the connection still works.
+func healthy(ctx context.Context, client *NNTPClient) bool {
+	_, _, err := client.Command(ctx, "DATE", 111)
+	return err == nil
+}
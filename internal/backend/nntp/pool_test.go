@@ -0,0 +1,270 @@
+package nntp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"newsmere/internal/types"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDialer dials fake servers answering DATE until they are hung up.
+type testDialer struct {
+	dials   int
+	servers []net.Conn
+}
+
+func (d *testDialer) dial(ctx context.Context) (*NNTPClient, error) {
+	client, server := net.Pipe()
+	d.dials++
+	d.servers = append(d.servers, server)
+
+	go func() {
+		server.Write([]byte("200 ready\r\n"))
+		r := bufio.NewReader(server)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "DATE") {
+				server.Write([]byte("111 20220101000000\r\n"))
+			} else {
+				server.Write([]byte("500 unknown\r\n"))
+			}
+		}
+	}()
+
+	return NewConnClient(ctx, client)
+}
+
+func TestPool(t *testing.T) {
+	d := &testDialer{}
+	p := newPool("test", 2, d.dial)
+
+	c1, err := p.get()
+	if err != nil {
+		t.Fatalf("Error getting connection: %v", err)
+	}
+	c2, _ := p.get()
+
+	// the third has to wait for one to be put back
+	got := make(chan *NNTPClient)
+	go func() {
+		c, _ := p.get()
+		got <- c
+	}()
+	select {
+	case <-got:
+		t.Fatalf("Pool exceeded its size")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	p.put(c1, &textproto.Error{Code: 423})
+	if c := <-got; c != c1 {
+		t.Errorf("Connection was not reused")
+	}
+	if d.dials != 2 {
+		t.Errorf("Expected 2 dials, got %d", d.dials)
+	}
+
+	// broken connections are replaced
+	p.put(c2, errors.New("broken pipe"))
+	c2, _ = p.get()
+	if d.dials != 3 {
+		t.Errorf("Expected 3 dials, got %d", d.dials)
+	}
+
+	// idle connections are checked before reuse
+	p.put(c1, nil)
+	p.put(c2, nil)
+	p.idle[0].since = time.Time{}
+	p.idle[1].since = time.Time{}
+	d.servers[2].Close()
+	c1, err = p.get()
+	if err != nil {
+		t.Fatalf("Error getting connection: %v", err)
+	}
+	if d.dials != 3 || p.open() != 1 || len(p.slots) != 1 {
+		t.Errorf("Unhealthy connection reused: %d dials, %d open, %d slots",
+			d.dials, p.open(), len(p.slots))
+	}
+	c2, err = p.get()
+	if err != nil {
+		t.Fatalf("Error getting connection: %v", err)
+	}
+	if d.dials != 4 || len(p.slots) != 2 {
+		t.Errorf("Unhealthy connection reused: %d dials, %d slots", d.dials, len(p.slots))
+	}
+
+	// all idle connections may have been dropped by the server
+	p.put(c1, nil)
+	p.put(c2, nil)
+	p.idle[0].since = time.Time{}
+	p.idle[1].since = time.Time{}
+	d.servers[0].Close()
+	d.servers[3].Close()
+	go func() {
+		c, _ := p.get()
+		got <- c
+	}()
+	select {
+	case c := <-got:
+		if c == nil || d.dials != 5 || p.open() != 1 || len(p.slots) != 1 {
+			t.Errorf("Unexpected pool: %d dials, %d open, %d slots",
+				d.dials, p.open(), len(p.slots))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Getting a connection hung after dropping stale ones")
+	}
+
+	p.close()
+	if _, err := p.get(); err != errPoolClosed {
+		t.Errorf("Expected errPoolClosed, got %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for failures, base := range []time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		4:  8 * time.Second,
+		20: 5 * time.Minute,
+	} {
+		if base == 0 {
+			continue
+		}
+		d := backoff(failures)
+		if d < base*8/10 || d > base*12/10 {
+			t.Errorf("backoff(%d) = %v, expected about %v", failures, d, base)
+		}
+	}
+}
+
+func TestIsBroken(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		broken bool
+	}{
+		{nil, false},
+		{&textproto.Error{Code: 423}, false},
+		{&textproto.Error{Code: 400}, true},
+		{io.EOF, true},
+		{fmt.Errorf("reading: %w", io.ErrUnexpectedEOF), true},
+		{&net.OpError{Op: "read", Err: errors.New("connection reset")}, true},
+		{errors.New("database is locked"), false},
+	} {
+		if got := isBroken(tc.err); got != tc.broken {
+			t.Errorf("isBroken(%v) = %v", tc.err, got)
+		}
+	}
+}
+
+func TestWithClientRetry(t *testing.T) {
+	defer func(d time.Duration) { minBackoff = d }(minBackoff)
+	minBackoff = time.Millisecond
+
+	d := &testDialer{}
+	b := &Backend{Name: "test"}
+	b.pools = []*pool{newPool("test", 1, d.dial)}
+	if b.Status() != types.StatusDown {
+		t.Errorf("Unexpected status: %s", b.Status())
+	}
+
+	// the first connection breaks, the command is run again on a new one
+	calls := 0
+	err := b.withClient(true, func(ctx context.Context, c *NNTPClient) error {
+		calls++
+		if calls == 1 {
+			d.servers[0].Close()
+		}
+		_, _, err := c.Command(ctx, "DATE", 111)
+		return err
+	})
+	if err != nil || calls != 2 || d.dials != 2 {
+		t.Errorf("Unexpected result: %v after %d calls, %d dials", err, calls, d.dials)
+	}
+	if b.Status() != types.StatusUp {
+		t.Errorf("Unexpected status: %s", b.Status())
+	}
+
+	// commands that are not safe to repeat fail
+	calls = 0
+	err = b.withClient(false, func(ctx context.Context, c *NNTPClient) error {
+		calls++
+		return io.EOF
+	})
+	if err != io.EOF || calls != 1 {
+		t.Errorf("Unexpected result: %v after %d calls", err, calls)
+	}
+	if b.Status() != types.StatusDown {
+		t.Errorf("Unexpected status: %s", b.Status())
+	}
+}
+
+func TestWithClientFailover(t *testing.T) {
+	primary, backfill := &testDialer{}, &testDialer{}
+	down := false
+	b := &Backend{Name: "test"}
+	b.pools = []*pool{
+		newPool("primary", 1, func(ctx context.Context) (*NNTPClient, error) {
+			if down {
+				return nil, errors.New("connection refused")
+			}
+			return primary.dial(ctx)
+		}),
+		newPool("backfill", 1, func(ctx context.Context) (*NNTPClient, error) {
+			c, err := backfill.dial(ctx)
+			if err == nil {
+				c.server = 1
+			}
+			return c, err
+		}),
+	}
+
+	missing := &textproto.Error{Code: 430, Msg: "No such article"}
+	tests := []struct {
+		name    string
+		down    bool
+		missing map[string]bool
+		want    string
+		err     error
+	}{
+		{"primary", false, nil, "primary", nil},
+		{"backfill", false, map[string]bool{"primary": true}, "primary backfill", nil},
+		{"missing", false, map[string]bool{"primary": true, "backfill": true}, "primary backfill", missing},
+		// the connection to the primary breaks
+		{"unreachable", true, nil, "primary backfill", nil},
+		// the primary is avoided while backing off
+		{"backoff", true, nil, "backfill", nil},
+	}
+	for _, tt := range tests {
+		if tt.down && !down {
+			primary.servers[0].Close()
+		}
+		down = tt.down
+		var tried []string
+		err := b.withClient(true, func(ctx context.Context, c *NNTPClient) error {
+			server := b.serverName(c)
+			tried = append(tried, server)
+			if tt.missing[server] {
+				return missing
+			}
+			_, _, err := c.Command(ctx, "DATE", 111)
+			return err
+		})
+		if err != tt.err || strings.Join(tried, " ") != tt.want {
+			t.Errorf("%s: tried %v: %v", tt.name, tried, err)
+		}
+	}
+
+	if b.Status() != types.StatusDegraded {
+		t.Errorf("Unexpected status: %s", b.Status())
+	}
+}
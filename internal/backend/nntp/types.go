@@ -4,10 +4,25 @@ import (
 	"io"
 	"net"
 	"net/textproto"
+	"sync"
+	"time"
 )
 
 const Type = "nntp"
 
+const (
+	// overBatch limits the number of articles asked for in a single OVER.
+	overBatch = 1000
+	// defaultMaxConns is the number of connections to a server unless
+	// configured otherwise.
+	defaultMaxConns = 2
+	// maxAttempts limits the tries of a command on broken connections.
+	maxAttempts = 5
+	// defaultTimeout is the number of seconds a command may take unless
+	// configured otherwise.
+	defaultTimeout = 60
+)
+
 // Group represents a usenet newsgroup.
 type Group struct {
 	Name        string
@@ -26,6 +41,18 @@ type Article struct {
 	Lines  int
 }
 
+// Overview is a parsed line of an OVER response.
+type Overview struct {
+	Number     int64
+	Subject    string
+	From       string
+	Date       time.Time
+	MessageID  string
+	References string
+	Bytes      int
+	Lines      int
+}
+
 // NNTPClient is an NNTP client.
 type NNTPClient struct {
 	conn         *textproto.Conn
@@ -33,14 +60,61 @@ type NNTPClient struct {
 	tls          bool
 	Banner       string
 	capabilities []string
+	// Timeout bounds each command including the reading of its response,
+	// zero for no limit.
+	Timeout time.Duration
+	// server is the index of the backend server the connection belongs
+	// to
+	server int
 }
 
-type Backend struct {
-	Name   string `json:"name"`
-	User   string `json:"user,omitempty"`
-	Pass   string `json:"pass,omitempty"`
+// ServerConfig is a server of a provider.
+type ServerConfig struct {
 	Server string `json:"server"`
 	Port   int    `json:"port,omitempty"`
+	User   string `json:"user,omitempty"`
+	Pass   string `json:"pass,omitempty"`
+	// TLS connects with TLS right away, StartTLS upgrades a plain
+	// connection with STARTTLS.
+	TLS      bool `json:"tls,omitempty"`
+	StartTLS bool `json:"starttls,omitempty"`
+	// CAFile is a PEM bundle of the CAs to trust instead of the system
+	// ones, CertFile and KeyFile are a client certificate.
+	CAFile   string `json:"ca_file,omitempty"`
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// InsecureSkipVerify accepts any server certificate, for lab servers
+	// only.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+	// MaxConns is the number of connections the server allows, groups
+	// are synced in parallel on them.
+	MaxConns int `json:"max_connections,omitempty"`
+	// Priority orders the servers, the lowest first. The next server is
+	// tried when one is unreachable or misses an article.
+	Priority int `json:"priority,omitempty"`
+}
+
+type Backend struct {
+	Name string `json:"name"`
+	// the main server, more may be listed in Servers
+	ServerConfig
+	// Servers are further servers of the same provider, e.g. a block
+	// account for backfill.
+	Servers []ServerConfig `json:"servers,omitempty"`
+	// Groups is a wildmat selecting the remote groups to subscribe to,
+	// all groups if empty.
+	Groups string `json:"groups,omitempty"`
+	// Exclude is a wildmat of groups not to serve even though they match
+	// Groups.
+	Exclude string `json:"exclude,omitempty"`
+	// MaxGroups caps the number of served groups, 0 for no limit.
+	MaxGroups int `json:"max_groups,omitempty"`
+	// Timeout is the number of seconds a command, or connecting, may take
+	// before the connection is considered broken.
+	Timeout int `json:"timeout,omitempty"`
 
-	client *NNTPClient
+	mu sync.Mutex
+	// pools holds a connection pool for each server, by priority
+	pools   []*pool
+	stopped bool
 }
@@ -0,0 +1,190 @@
+package rss
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var dateLayouts = []string{
+	time.RFC3339,
+	time.RFC3339Nano,
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// ParseFeed parses an RSS 2.0, RSS 1.0 or Atom document.
+func ParseFeed(r io.Reader) (*Feed, error) {
+	var doc rssDoc
+
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.CharsetReader = charsetReader
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(doc.XMLName.Local) {
+	case "rss":
+		return rssFeed(doc.Channel.Title, doc.Channel.Link,
+			doc.Channel.Description, doc.Channel.Items), nil
+	case "rdf":
+		return rssFeed(doc.Channel.Title, doc.Channel.Link,
+			doc.Channel.Description, doc.Items), nil
+	case "feed":
+		return atomFeed(&doc), nil
+	default:
+		return nil, fmt.Errorf("unknown feed format: %s", doc.XMLName.Local)
+	}
+}
+
+func rssFeed(title, link, description string, items []rssItem) *Feed {
+	feed := &Feed{
+		Title:       strings.TrimSpace(title),
+		Link:        strings.TrimSpace(link),
+		Description: strings.TrimSpace(description),
+	}
+
+	for _, i := range items {
+		item := Item{
+			GUID:    strings.TrimSpace(i.GUID),
+			Title:   strings.TrimSpace(i.Title),
+			Link:    strings.TrimSpace(i.Link),
+			Author:  strings.TrimSpace(i.Author),
+			Date:    parseDate(i.PubDate),
+			Content: i.Encoded,
+		}
+		if item.GUID == "" {
+			item.GUID = i.About
+		}
+		if item.Author == "" {
+			item.Author = strings.TrimSpace(i.Creator)
+		}
+		if item.Date.IsZero() {
+			item.Date = parseDate(i.DcDate)
+		}
+		if item.Content == "" {
+			item.Content = i.Description
+		}
+		feed.Items = append(feed.Items, item)
+	}
+
+	return feed
+}
+
+func atomFeed(doc *rssDoc) *Feed {
+	feed := &Feed{
+		Title:       strings.TrimSpace(doc.Title),
+		Link:        alternateLink(doc.Links),
+		Description: strings.TrimSpace(doc.Subtitle),
+	}
+
+	for _, e := range doc.Entries {
+		item := Item{
+			GUID:    strings.TrimSpace(e.ID),
+			Title:   strings.TrimSpace(e.Title),
+			Link:    alternateLink(e.Links),
+			Author:  strings.TrimSpace(e.Author.Name),
+			Date:    parseDate(e.Published),
+			Content: e.Content.String(),
+		}
+		if e.Author.Email != "" {
+			item.Author = (&mail.Address{
+				Name:    item.Author,
+				Address: strings.TrimSpace(e.Author.Email),
+			}).String()
+		}
+		if item.Date.IsZero() {
+			item.Date = parseDate(e.Updated)
+		}
+		if item.Content == "" {
+			item.Content = e.Summary.String()
+		}
+		feed.Items = append(feed.Items, item)
+	}
+
+	return feed
+}
+
+// String returns the html or text content of an atom text construct.
+func (t atomText) String() string {
+	if strings.ToLower(t.Type) == "xhtml" {
+		return strings.TrimSpace(t.Inner)
+	}
+	return strings.TrimSpace(t.Text)
+}
+
+func alternateLink(links []atomLink) string {
+	for _, l := range links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return l.Href
+		}
+	}
+	if len(links) > 0 {
+		return links[0].Href
+	}
+	return ""
+}
+
+func parseDate(value string) time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}
+	}
+
+	if t, err := mail.ParseDate(value); err == nil {
+		return t
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// charsetReader converts the latin-1 family to utf-8, which are the only
+// non utf-8 encodings commonly found in feeds.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "iso-8859-15", "latin1", "windows-1252", "cp1252":
+		return &latin1Reader{r: bufio.NewReader(input)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported charset: %s", charset)
+	}
+}
+
+type latin1Reader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(l.buf) == 0 {
+			b, err := l.r.ReadByte()
+			if err != nil {
+				if n > 0 {
+					return n, nil
+				}
+				return 0, err
+			}
+			l.buf = utf8.AppendRune(l.buf[:0], rune(b))
+		}
+		c := copy(p[n:], l.buf)
+		l.buf = l.buf[c:]
+		n += c
+	}
+	return n, nil
+}
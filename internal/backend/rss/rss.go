@@ -0,0 +1,252 @@
+package rss
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"newsmere/internal/storage"
+	"newsmere/internal/types"
+	"strings"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+func New(config json.RawMessage) (*Backend, error) {
+	backend := new(Backend)
+	err := json.Unmarshal(config, &backend)
+	backend.client = &http.Client{Timeout: 30 * time.Second}
+	backend.status = types.StatusDown
+	return backend, err
+}
+
+func (*Backend) Type() string {
+	return Type
+}
+
+func (b *Backend) Start() error {
+	fmt.Printf("[Backend] %s-%s starting\n", b.Type(), b.Name)
+	return nil
+}
+
+// Sync polls all feeds.
+func (b *Backend) Sync() error {
+	err := b.syncFeeds()
+	fmt.Printf("[Backend] %s-%s sync feeds finished\n", b.Type(), b.Name)
+	return err
+}
+
+func (b *Backend) Stop() error {
+	b.setStatus(types.StatusDown)
+	return nil
+}
+
+func (b *Backend) Restart() error {
+	if err := b.Stop(); err != nil {
+		return err
+	}
+
+	return b.Start()
+}
+
+func (b *Backend) Status() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.status
+}
+
+func (b *Backend) setStatus(status string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.status = status
+}
+
+func (b *Backend) syncFeeds() error {
+	var errs []string
+	for _, f := range b.Feeds {
+		if err := b.syncFeed(f); err != nil {
+			log.Printf("[Backend] %s-%s feed %s: %v", b.Type(), b.Name, f.Name, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", f.Name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		b.setStatus(types.StatusDown)
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	b.setStatus(types.StatusUp)
+	return nil
+}
+
+func (b *Backend) syncFeed(config FeedConfig) error {
+	feed, err := b.fetch(config.URL)
+	if err != nil {
+		return err
+	}
+
+	group, err := b.ensureGroup(config, feed)
+	if err != nil {
+		return err
+	}
+
+	if !group.Enabled {
+		return nil
+	}
+
+	db := storage.GetDb()
+	for i := len(feed.Items) - 1; i >= 0; i-- {
+		article, body, err := b.toArticle(config, group, &feed.Items[i])
+		if err != nil {
+			return err
+		}
+
+		err = storage.SaveArticle(db, article, []uint{group.ID},
+			strings.NewReader(body))
+		if err != nil && !errors.Is(err, storage.ErrArticleExists) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fetch downloads and parses a feed.
+func (b *Backend) fetch(feedURL string) (*Feed, error) {
+	resp, err := b.client.Get(feedURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return ParseFeed(resp.Body)
+}
+
+// ensureGroup creates the subscription and the served group of a feed.
+func (b *Backend) ensureGroup(config FeedConfig, feed *Feed) (*storage.Group, error) {
+	db := storage.GetDb()
+
+	description := feed.Title
+	if description == "" {
+		description = config.Name
+	}
+
+	sub := storage.Subscription{
+		Name:        config.Name,
+		Description: description,
+		Source:      b.Name,
+	}
+	result := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}, {Name: "source"}},
+		DoUpdates: clause.AssignmentColumns([]string{"description"}),
+	}).Create(&sub)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	group := storage.Group{
+		Name:        config.Name,
+		Description: description,
+		Source:      b.Name,
+	}
+	result = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}, {Name: "source"}},
+		DoUpdates: clause.AssignmentColumns([]string{"description"}),
+	}).Create(&group)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	result = db.Where("name = ? AND source = ?", config.Name, b.Name).First(&group)
+	return &group, result.Error
+}
+
+// toArticle turns a feed item into an article with synthesized RFC 5322
+// headers.
+func (b *Backend) toArticle(config FeedConfig, group *storage.Group, item *Item) (
+	*storage.Article, string, error) {
+	msgID := MessageID(config.URL, item)
+
+	date := item.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	from := item.Author
+	if _, err := mail.ParseAddress(from); err != nil {
+		name := item.Author
+		if name == "" {
+			name = group.Description
+		}
+		from = (&mail.Address{
+			Name:    name,
+			Address: "noreply@" + feedHost(config.URL),
+		}).String()
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Message-Id", msgID)
+	header.Set("From", from)
+	header.Set("Date", date.Format(time.RFC1123Z))
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", item.Title))
+	header.Set("Newsgroups", b.Name+"."+config.Name)
+	header.Set("Mime-Version", "1.0")
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	if item.Link != "" {
+		header.Set("Content-Base", item.Link)
+	}
+
+	headers, err := json.Marshal(header)
+	if err != nil {
+		return nil, "", err
+	}
+
+	body := item.Content
+	if item.Link != "" {
+		body += fmt.Sprintf("\n<p><a href=\"%s\">%s</a></p>\n", item.Link, item.Link)
+	}
+
+	return &storage.Article{
+		MsgID:    msgID,
+		DocType:  Type,
+		Title:    item.Title,
+		Author:   from,
+		PostedAt: date,
+		Headers:  headers,
+	}, body, nil
+}
+
+// MessageID derives a stable message-id from the GUID of an item, falling
+// back to its link or title for feeds without GUIDs.
+func MessageID(feedURL string, item *Item) string {
+	guid := item.GUID
+	if guid == "" {
+		guid = item.Link
+	}
+	if guid == "" {
+		guid = item.Title + item.Date.String()
+	}
+
+	return fmt.Sprintf("<%x@%s>", sha1.Sum([]byte(guid)), feedHost(feedURL))
+}
+
+func feedHost(feedURL string) string {
+	u, err := url.Parse(feedURL)
+	if err != nil || u.Hostname() == "" {
+		return "newsmere"
+	}
+	return u.Hostname()
+}
@@ -0,0 +1,188 @@
+package rss
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"newsmere/internal/storage"
+	"newsmere/internal/types"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRSS = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <channel>
+    <title>Test Feed</title>
+    <link>http://example.com/</link>
+    <description>A feed for testing</description>
+    <item>
+      <guid>http://example.com/2</guid>
+      <title>Second</title>
+      <link>http://example.com/2</link>
+      <dc:creator>Jane</dc:creator>
+      <pubDate>Tue, 03 Jan 2006 15:04:05 +0000</pubDate>
+      <description>&lt;p&gt;second&lt;/p&gt;</description>
+    </item>
+    <item>
+      <title>First</title>
+      <link>http://example.com/1</link>
+      <pubDate>Mon, 02 Jan 2006 15:04:05 +0000</pubDate>
+      <description>first</description>
+    </item>
+  </channel>
+</rss>`
+
+const testAtom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Atom Feed</title>
+  <link href="http://example.org/"/>
+  <entry>
+    <id>urn:uuid:1225c695-cfb8-4ebb-aaaa-80da344efa6a</id>
+    <title>Atom entry</title>
+    <link rel="alternate" href="http://example.org/1"/>
+    <updated>2006-01-02T15:04:05Z</updated>
+    <author><name>John</name><email>john@example.org</email></author>
+    <content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><p>hello</p></div></content>
+  </entry>
+</feed>`
+
+func newTestServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rss":
+			w.Write([]byte(testRSS))
+		case "/atom":
+			w.Write([]byte(testAtom))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestFetchRSS(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	b := &Backend{Name: "feeds", client: ts.Client()}
+	feed, err := b.fetch(ts.URL + "/rss")
+	if err != nil {
+		t.Fatalf("Error fetching feed: %v", err)
+	}
+
+	if feed.Title != "Test Feed" || len(feed.Items) != 2 {
+		t.Fatalf("Unexpected feed: %+v", feed)
+	}
+
+	item := feed.Items[0]
+	if item.GUID != "http://example.com/2" || item.Author != "Jane" ||
+		item.Content != "<p>second</p>" {
+		t.Errorf("Unexpected item: %+v", item)
+	}
+	if !item.Date.Equal(time.Date(2006, 1, 3, 15, 4, 5, 0, time.UTC)) {
+		t.Errorf("Unexpected date: %v", item.Date)
+	}
+}
+
+func TestFetchAtom(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	b := &Backend{Name: "feeds", client: ts.Client()}
+	feed, err := b.fetch(ts.URL + "/atom")
+	if err != nil {
+		t.Fatalf("Error fetching feed: %v", err)
+	}
+
+	if feed.Title != "Atom Feed" || feed.Link != "http://example.org/" ||
+		len(feed.Items) != 1 {
+		t.Fatalf("Unexpected feed: %+v", feed)
+	}
+
+	item := feed.Items[0]
+	if item.Link != "http://example.org/1" ||
+		item.Author != `"John" <john@example.org>` ||
+		!strings.Contains(item.Content, "<p>hello</p>") {
+		t.Errorf("Unexpected item: %+v", item)
+	}
+
+	if _, err := b.fetch(ts.URL + "/missing"); err == nil {
+		t.Errorf("Expected error for missing feed")
+	}
+}
+
+func TestToArticle(t *testing.T) {
+	b := &Backend{Name: "feeds"}
+	config := FeedConfig{Name: "test", URL: "http://example.com/rss"}
+	group := &storage.Group{Name: "test", Description: "Test Feed"}
+	item := &Item{
+		GUID:  "http://example.com/1",
+		Title: "Grüße",
+		Link:  "http://example.com/1",
+	}
+
+	article, body, err := b.toArticle(config, group, item)
+	if err != nil {
+		t.Fatalf("Error converting item: %v", err)
+	}
+
+	if article.MsgID != MessageID(config.URL, item) ||
+		!strings.HasSuffix(article.MsgID, "@example.com>") {
+		t.Errorf("Unexpected message-id: %s", article.MsgID)
+	}
+	if !strings.Contains(string(article.Headers), `"Newsgroups":["feeds.test"]`) ||
+		!strings.Contains(string(article.Headers), `"Subject":["=?utf-8?q?Gr=C3=BC=C3=9Fe?="]`) ||
+		!strings.Contains(string(article.Headers), `noreply@example.com`) {
+		t.Errorf("Unexpected headers: %s", article.Headers)
+	}
+	if !strings.Contains(body, item.Link) {
+		t.Errorf("Body lacks link: %s", body)
+	}
+}
+
+func TestSyncFeeds(t *testing.T) {
+	if err := storage.Open(storage.Config{DSN: ":memory:"}); err != nil {
+		t.Fatalf("Error opening storage: %v", err)
+	}
+	defer storage.Close()
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	b := &Backend{
+		Name:   "feeds",
+		Feeds:  []FeedConfig{{Name: "test", URL: ts.URL + "/rss"}},
+		client: ts.Client(),
+	}
+
+	// polling again stores no duplicates
+	for i := 0; i < 2; i++ {
+		if err := b.Sync(); err != nil {
+			t.Fatalf("Error syncing: %v", err)
+		}
+		if b.Status() != types.StatusUp {
+			t.Errorf("Unexpected status: %s", b.Status())
+		}
+
+		var links []storage.GroupArticle
+		storage.GetDb().Preload("Article").Order("number").Find(&links)
+		if len(links) != 2 || links[0].Article.Title != "First" ||
+			links[1].Article.Title != "Second" || links[1].Number != 2 {
+			t.Errorf("Unexpected articles: %+v", links)
+		}
+	}
+
+	var group storage.Group
+	storage.GetDb().Where("name = ? AND source = ?", "test", b.Name).First(&group)
+	if group.Description != "Test Feed" || group.Count != 2 || group.High != 2 {
+		t.Errorf("Unexpected group: %+v", group)
+	}
+
+	b.Feeds = append(b.Feeds, FeedConfig{Name: "missing", URL: ts.URL + "/missing"})
+	if err := b.Sync(); err == nil {
+		t.Errorf("Expected sync to fail")
+	}
+	if b.Status() != types.StatusDown {
+		t.Errorf("Unexpected status: %s", b.Status())
+	}
+}
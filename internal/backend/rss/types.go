@@ -0,0 +1,101 @@
+package rss
+
+import (
+	"encoding/xml"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const Type = "rss"
+
+// Feed is a parsed RSS or Atom document.
+type Feed struct {
+	Title       string
+	Link        string
+	Description string
+	Items       []Item
+}
+
+// Item is a single entry of a feed.
+type Item struct {
+	GUID    string
+	Title   string
+	Link    string
+	Author  string
+	Date    time.Time
+	Content string
+}
+
+// rssDoc covers RSS 2.0 and RSS 1.0 (RDF), whose items are siblings of the
+// channel instead of its children.
+type rssDoc struct {
+	XMLName xml.Name
+	Channel struct {
+		Title       string    `xml:"title"`
+		Link        string    `xml:"link"`
+		Description string    `xml:"description"`
+		Items       []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items []rssItem `xml:"item"`
+
+	// Atom
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	GUID        string `xml:"guid"`
+	About       string `xml:"about,attr"`
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Author      string `xml:"author"`
+	Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	PubDate     string `xml:"pubDate"`
+	DcDate      string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Description string `xml:"description"`
+	Encoded     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Text  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Links     []atomLink `xml:"link"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Author    struct {
+		Name  string `xml:"name"`
+		Email string `xml:"email"`
+	} `xml:"author"`
+	Summary atomText `xml:"summary"`
+	Content atomText `xml:"content"`
+}
+
+// FeedConfig maps a feed url to a served group.
+type FeedConfig struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type Backend struct {
+	Name  string       `json:"name"`
+	Feeds []FeedConfig `json:"feeds"`
+
+	client *http.Client
+
+	mu     sync.Mutex
+	status string
+}
@@ -2,17 +2,42 @@ package engine
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"newsmere/internal/operator"
+	"newsmere/internal/storage"
 	"os"
+	"sync"
+	"time"
 )
 
+// stopTimeout bounds the time to wait for components to return after they
+// were stopped.
+var stopTimeout = 30 * time.Second
+
 // Engine for managing the whole system.
 type Engine struct {
-	Backends []Backend `json:"backends"`
-	Services []Service `json:"services"`
+	Backends []Backend      `json:"backends"`
+	Services []Service      `json:"services"`
+	Storage  storage.Config `json:"storage"`
+
+	jobs []*syncJob
+	errc chan error
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+}
+
+// componentError is an error reported by a running backend or service.
+type componentError struct {
+	kind string
+	name string
+	err  error
 }
 
-func New(configFile string) Engine {
+func New(configFile string) *Engine {
 	if configFile == "" {
 		configFile = "config.json"
 	}
@@ -22,35 +47,52 @@ func New(configFile string) Engine {
 		log.Fatal(err)
 	}
 
-	var e Engine
-	err = json.Unmarshal(configBytes, &e)
+	e := &Engine{
+		done: make(chan struct{}),
+	}
+	err = json.Unmarshal(configBytes, e)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = storage.Open(e.Storage)
 	if err != nil {
 		log.Fatal(err)
 	}
 	return e
 }
 
-func (e *Engine) UnmarshalJSON(b []byte) error {
-	type engine2 *Engine
-	_ = json.Unmarshal(b, engine2(e))
+func (e *componentError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.kind, e.name, e.err)
+}
+
+func (e *componentError) Unwrap() error {
+	return e.err
+}
 
+func (e *Engine) UnmarshalJSON(b []byte) error {
 	// clean the state
 	e.Backends = []Backend{}
 	e.Services = []Service{}
+	e.jobs = nil
 
 	raw := struct {
 		Backends []json.RawMessage `json:"backends"`
 		Services []json.RawMessage `json:"services"`
+		Storage  storage.Config    `json:"storage"`
 	}{}
 
 	err := json.Unmarshal(b, &raw)
 	if err != nil {
 		return err
 	}
+	e.Storage = raw.Storage
 
 	configTypes := struct {
 		Backends []struct {
-			Type string `json:"type"`
+			Type     string `json:"type"`
+			Name     string `json:"name"`
+			Interval string `json:"interval"`
 		}
 		Services []struct {
 			Type string `json:"type"`
@@ -61,16 +103,40 @@ func (e *Engine) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
+	// backends taking posts, by the source name of their groups
+	posters := make(map[string]operator.Poster)
+
 	for i, b := range configTypes.Backends {
 		backend, err := backendDecode(b.Type, raw.Backends[i])
 		if err != nil {
 			return err
 		}
 		e.Backends = append(e.Backends, backend)
+		if poster, ok := backend.(operator.Poster); ok {
+			posters[b.Name] = poster
+		}
+
+		interval := defaultSyncInterval
+		if b.Interval != "" {
+			interval, err = time.ParseDuration(b.Interval)
+			if err != nil {
+				return fmt.Errorf(errInvalidInterval, b.Name, err)
+			}
+		}
+		if interval <= 0 {
+			return fmt.Errorf(errInvalidInterval, b.Name, b.Interval)
+		}
+
+		e.jobs = append(e.jobs, &syncJob{
+			name:     b.Type + "-" + b.Name,
+			backend:  backend,
+			interval: interval,
+			state:    SyncState{Backend: b.Type + "-" + b.Name},
+		})
 	}
 
 	for i, s := range configTypes.Services {
-		service, err := serviceDecode(s.Type, raw.Services[i])
+		service, err := serviceDecode(s.Type, raw.Services[i], posters)
 		if err != nil {
 			return err
 		}
@@ -79,20 +145,106 @@ func (e *Engine) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// Run starts every backend and service in its own goroutine and blocks
+// until the engine is shut down or a service fails. Backends are synced
+// periodically, failed backends are only logged, they don't affect the
+// other sources.
 func (e *Engine) Run() error {
-	for _, b := range e.Backends {
-		err := b.Start()
-		if err != nil {
+	e.errc = make(chan error, len(e.Backends)+len(e.Services))
+
+	for _, job := range e.jobs {
+		job := job
+		e.start("backend", job.name, func() error {
+			if err := job.backend.Start(); err != nil {
+				return err
+			}
+			e.schedule(job)
+			return nil
+		})
+	}
+
+	for _, s := range e.Services {
+		e.start("service", s.Type(), s.Start)
+	}
+
+	for {
+		select {
+		case err := <-e.errc:
+			var cerr *componentError
+			if errors.As(err, &cerr) && cerr.kind == "backend" {
+				log.Printf("[Engine] %v", err)
+				continue
+			}
+			log.Printf("[Engine] %v, shutting down", err)
+			e.stop()
 			return err
+		case <-e.done:
+			return e.stop()
 		}
 	}
+}
+
+// start runs fn in a goroutine and reports its failure to the error
+// channel.
+func (e *Engine) start(kind, name string, fn func() error) {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		if err := fn(); err != nil {
+			e.errc <- &componentError{kind: kind, name: name, err: err}
+		}
+	}()
+}
+
+// stop stops the services first so that no new requests come in, then
+// the backends, and flushes the storage once everything returned. The
+// storage is left open if components are still running after stopTimeout.
+func (e *Engine) stop() error {
+	var errs []error
+
+	// ends the sync schedules
+	e.Shutdown()
 
 	for _, s := range e.Services {
-		err := s.Start()
-		if err != nil {
-			return err
+		if err := s.Stop(); err != nil {
+			errs = append(errs, &componentError{kind: "service", name: s.Type(), err: err})
 		}
 	}
 
+	for _, b := range e.Backends {
+		if err := b.Stop(); err != nil {
+			errs = append(errs, &componentError{kind: "backend", name: b.Type(), err: err})
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		if err := storage.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	case <-time.After(stopTimeout):
+		// the storage stays open for them, the process is about to end
+		errs = append(errs, fmt.Errorf(errStillRunning, stopTimeout))
+	}
+
+	for _, err := range errs {
+		log.Printf("[Engine] %v", err)
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
 	return nil
 }
+
+// Shutdown makes Run stop all components and return.
+func (e *Engine) Shutdown() {
+	e.once.Do(func() {
+		close(e.done)
+	})
+}
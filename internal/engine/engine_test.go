@@ -1,8 +1,12 @@
 package engine
 
 import (
+	"errors"
+	"newsmere/internal/storage"
 	"os"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestJSON(t *testing.T) {
@@ -30,17 +34,158 @@ func TestNew(t *testing.T) {
 func TestRun(t *testing.T) {
 	e := New("testdata/config.json")
 
-	for _, b := range e.Backends {
-		err := b.Start()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- e.Run()
+	}()
+
+	e.Shutdown()
+
+	select {
+	case err := <-errc:
 		if err != nil {
-			t.Fatalf("Error start backend (%s): %v", b.Type(), err)
+			t.Fatalf("Error running engine: %v", err)
 		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run did not return after shutdown")
 	}
+}
 
-	for _, s := range e.Services {
-		err := s.Start()
-		if err != nil {
-			t.Fatalf("Error start service (%s): %v", s.Type(), err)
-		}
+// countingBackend fails every other sync and records overlapping syncs.
+type countingBackend struct {
+	syncs   int32
+	running int32
+	overlap int32
+}
+
+func (b *countingBackend) Type() string   { return "counting" }
+func (b *countingBackend) Start() error   { return nil }
+func (b *countingBackend) Stop() error    { return nil }
+func (b *countingBackend) Restart() error { return nil }
+func (b *countingBackend) Status() string { return "up" }
+
+func (b *countingBackend) Sync() error {
+	if atomic.AddInt32(&b.running, 1) > 1 {
+		atomic.StoreInt32(&b.overlap, 1)
+	}
+	defer atomic.AddInt32(&b.running, -1)
+
+	time.Sleep(20 * time.Millisecond)
+	if atomic.AddInt32(&b.syncs, 1)%2 == 1 {
+		return errors.New("sync failed")
+	}
+	return nil
+}
+
+func TestSchedule(t *testing.T) {
+	if err := storage.Open(storage.Config{DSN: ":memory:"}); err != nil {
+		t.Fatalf("Error opening storage: %v", err)
+	}
+
+	backend := new(countingBackend)
+	e := &Engine{
+		Backends: []Backend{backend},
+		jobs: []*syncJob{{
+			name:     "counting-test",
+			backend:  backend,
+			interval: time.Millisecond,
+			state:    SyncState{Backend: "counting-test"},
+		}},
+		done: make(chan struct{}),
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- e.Run()
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&backend.syncs) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the states are stored for other processes, the storage is closed
+	// when the engine stops
+	stored, err := storage.ListSyncStates(storage.GetDb())
+	if err != nil {
+		t.Fatalf("Error listing sync states: %v", err)
+	}
+	if len(stored) != 1 || stored[0].Backend != "counting-test" ||
+		stored[0].LastSync.IsZero() || stored[0].LastStart.IsZero() {
+		t.Errorf("Unexpected stored sync states: %+v", stored)
+	}
+
+	e.Shutdown()
+	if err := <-errc; err != nil {
+		t.Fatalf("Error running engine: %v", err)
+	}
+
+	if syncs := atomic.LoadInt32(&backend.syncs); syncs < 3 {
+		t.Fatalf("Expected at least 3 syncs, got %d", syncs)
+	}
+	if atomic.LoadInt32(&backend.overlap) != 0 {
+		t.Errorf("Syncs of the same backend overlapped")
+	}
+
+	states := e.SyncStates()
+	if len(states) != 1 || states[0].Backend != "counting-test" ||
+		states[0].LastSync.IsZero() || states[0].Running {
+		t.Errorf("Unexpected sync states: %+v", states)
+	}
+}
+
+// blockingBackend syncs until release is closed.
+type blockingBackend struct {
+	countingBackend
+	syncing chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingBackend) Sync() error {
+	close(b.syncing)
+	<-b.release
+	return nil
+}
+
+func TestStopTimeout(t *testing.T) {
+	if err := storage.Open(storage.Config{DSN: ":memory:"}); err != nil {
+		t.Fatalf("Error opening storage: %v", err)
+	}
+	defer storage.Close()
+
+	defer func(timeout time.Duration) { stopTimeout = timeout }(stopTimeout)
+	stopTimeout = 50 * time.Millisecond
+
+	backend := &blockingBackend{
+		syncing: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	e := &Engine{
+		Backends: []Backend{backend},
+		jobs: []*syncJob{{
+			name:     "blocking-test",
+			backend:  backend,
+			interval: time.Hour,
+			state:    SyncState{Backend: "blocking-test"},
+		}},
+		done: make(chan struct{}),
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- e.Run()
+	}()
+	<-backend.syncing
+
+	e.Shutdown()
+	if err := <-errc; err == nil {
+		t.Errorf("Expected an error for the running sync")
+	}
+
+	// the sync still running may use the storage
+	close(backend.release)
+	e.wg.Wait()
+	if err := storage.GetDb().Error; err != nil {
+		t.Errorf("Storage closed under the running sync: %v", err)
 	}
 }
@@ -3,4 +3,6 @@ package engine
 const (
 	errUnknownBackendType = "unknown backend type: %s"
 	errUnknownServiceType = "unknown service type: %s"
+	errInvalidInterval    = "invalid sync interval of backend %s: %v"
+	errStillRunning       = "components still running after %v"
 )
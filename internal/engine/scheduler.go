@@ -0,0 +1,116 @@
+package engine
+
+import (
+	"log"
+	"math/rand"
+	"newsmere/internal/storage"
+	"time"
+)
+
+const (
+	defaultSyncInterval = 15 * time.Minute
+	// syncJitter is the fraction of the interval by which a sync may be
+	// moved, so that backends don't hit their servers in lockstep.
+	syncJitter = 0.1
+)
+
+// SyncState records the outcome of the syncs of a backend.
+type SyncState struct {
+	Backend   string
+	Running   bool
+	LastStart time.Time
+	LastSync  time.Time
+	LastError string
+	NextSync  time.Time
+}
+
+// syncJob schedules the syncs of a single backend.
+type syncJob struct {
+	name     string
+	backend  Backend
+	interval time.Duration
+	state    SyncState
+}
+
+// schedule syncs the backend right away and then once per interval until
+// the engine is shut down. Syncs of a job never overlap since they run one
+// after the other in this loop.
+func (e *Engine) schedule(job *syncJob) {
+	for {
+		e.runSync(job)
+
+		wait := jitter(job.interval)
+		e.mu.Lock()
+		job.state.NextSync = time.Now().Add(wait)
+		e.mu.Unlock()
+		e.saveState(job)
+
+		select {
+		case <-time.After(wait):
+		case <-e.done:
+			return
+		}
+	}
+}
+
+func (e *Engine) runSync(job *syncJob) {
+	e.mu.Lock()
+	job.state.Running = true
+	job.state.LastStart = time.Now()
+	e.mu.Unlock()
+	e.saveState(job)
+
+	err := job.backend.Sync()
+
+	e.mu.Lock()
+	job.state.Running = false
+	job.state.LastSync = time.Now()
+	job.state.LastError = ""
+	if err != nil {
+		job.state.LastError = err.Error()
+	}
+	e.mu.Unlock()
+
+	if err != nil {
+		log.Printf("[Engine] sync of backend %s failed: %v", job.name, err)
+	}
+}
+
+// saveState stores the sync state of the job, so that other processes can
+// report it.
+func (e *Engine) saveState(job *syncJob) {
+	e.mu.Lock()
+	state := job.state
+	e.mu.Unlock()
+
+	err := storage.SaveSyncState(storage.GetDb(), &storage.SyncState{
+		Backend:   state.Backend,
+		Running:   state.Running,
+		LastStart: state.LastStart,
+		LastSync:  state.LastSync,
+		LastError: state.LastError,
+		NextSync:  state.NextSync,
+	})
+	if err != nil {
+		log.Printf("[Engine] saving sync state of backend %s failed: %v", job.name, err)
+	}
+}
+
+// SyncStates returns the sync state of every backend.
+func (e *Engine) SyncStates() []SyncState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	states := make([]SyncState, 0, len(e.jobs))
+	for _, job := range e.jobs {
+		states = append(states, job.state)
+	}
+	return states
+}
+
+// jitter moves the interval randomly by up to syncJitter in both
+// directions.
+func jitter(interval time.Duration) time.Duration {
+	delta := time.Duration(float64(interval) * syncJitter * (2*rand.Float64() - 1))
+	return interval + delta
+}
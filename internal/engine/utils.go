@@ -3,7 +3,9 @@ package engine
 import (
 	"encoding/json"
 	"fmt"
+	imap_bk "newsmere/internal/backend/imap"
 	nntp_bk "newsmere/internal/backend/nntp"
+	rss_bk "newsmere/internal/backend/rss"
 	"newsmere/internal/operator"
 	nntp_sv "newsmere/internal/service/nntp"
 )
@@ -12,15 +14,24 @@ func backendDecode(typeName string, config json.RawMessage) (Backend, error) {
 	switch typeName {
 	case nntp_bk.Type:
 		return nntp_bk.New(config)
+	case imap_bk.Type:
+		return imap_bk.New(config)
+	case rss_bk.Type:
+		return rss_bk.New(config)
 	default:
 		return nil, fmt.Errorf(errUnknownBackendType, typeName)
 	}
 }
 
-func serviceDecode(typeName string, config json.RawMessage) (Service, error) {
+func serviceDecode(typeName string, config json.RawMessage,
+	posters map[string]operator.Poster) (Service, error) {
 	switch typeName {
 	case nntp_sv.Type:
-		return nntp_sv.New(config, operator.New())
+		op, err := operator.New(config, posters)
+		if err != nil {
+			return nil, err
+		}
+		return nntp_sv.New(config, op)
 	default:
 		return nil, fmt.Errorf(errUnknownServiceType, typeName)
 	}
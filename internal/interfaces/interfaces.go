@@ -7,6 +7,7 @@ type Backend interface {
 	Stop() error
 	Restart() error
 	Status() string
+	Sync() error
 }
 
 type Service interface {
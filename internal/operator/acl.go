@@ -0,0 +1,123 @@
+package operator
+
+import (
+	"newsmere/internal/storage"
+	"newsmere/internal/wildmat"
+	"strings"
+)
+
+// RoleAnonymous is the role of sessions without authentication.
+const RoleAnonymous = "anonymous"
+
+// Rule grants or denies access to groups. A rule applies to the listed
+// users and roles, or to everyone if it lists neither. Groups are wildmats
+// on "source.name", a group matched by one prefixed with "!" is excluded
+// whatever the order. Post additionally allows posting to the groups.
+type Rule struct {
+	Users  []string `json:"users"`
+	Roles  []string `json:"roles"`
+	Groups []string `json:"groups"`
+	Deny   bool     `json:"deny"`
+	Post   bool     `json:"post"`
+}
+
+// ACL is an ordered list of rules, the first rule applying to the user
+// and matching the group decides. Without any rules every group is
+// visible, otherwise groups matched by no rule are hidden.
+type ACL []Rule
+
+// Allowed reports whether the user, nil for anonymous sessions, may access
+// the group.
+func (acl ACL) Allowed(user *storage.User, group string) bool {
+	if len(acl) == 0 {
+		return true
+	}
+
+	rule := acl.find(user, group)
+	return rule != nil && !rule.Deny
+}
+
+// PostAllowed reports whether the user may post to the group. Without any
+// rules every authenticated user may post.
+func (acl ACL) PostAllowed(user *storage.User, group string) bool {
+	if len(acl) == 0 {
+		return user != nil
+	}
+
+	rule := acl.find(user, group)
+	return rule != nil && !rule.Deny && rule.Post
+}
+
+// CanPost reports whether the user may post to any group at all.
+func (acl ACL) CanPost(user *storage.User) bool {
+	if len(acl) == 0 {
+		return user != nil
+	}
+
+	for i := range acl {
+		if acl[i].Post && !acl[i].Deny && acl[i].appliesTo(user) {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the rule deciding about the group.
+func (acl ACL) find(user *storage.User, group string) *Rule {
+	for i := range acl {
+		if acl[i].appliesTo(user) && acl[i].matches(group) {
+			return &acl[i]
+		}
+	}
+	return nil
+}
+
+func (r *Rule) appliesTo(user *storage.User) bool {
+	if len(r.Users) == 0 && len(r.Roles) == 0 {
+		return true
+	}
+
+	for _, role := range r.Roles {
+		if hasRole(user, role) {
+			return true
+		}
+	}
+
+	if user == nil {
+		return false
+	}
+	for _, name := range r.Users {
+		if name == user.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the group is matched by a pattern of the rule
+// and not excluded by a negated one.
+func (r *Rule) matches(group string) bool {
+	matched := false
+	for _, pattern := range r.Groups {
+		if strings.HasPrefix(pattern, "!") {
+			if wildmat.Match(pattern[1:], group) {
+				return false
+			}
+			continue
+		}
+		if wildmat.Match(pattern, group) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+func hasRole(user *storage.User, role string) bool {
+	if user == nil {
+		return role == RoleAnonymous
+	}
+	if user.IsAdmin && role == "admin" {
+		return true
+	}
+	return user.Role != "" && user.Role == role
+}
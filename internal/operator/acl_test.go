@@ -0,0 +1,96 @@
+package operator
+
+import (
+	"errors"
+	nntp_sv "newsmere/internal/service/nntp"
+	"newsmere/internal/storage"
+	"testing"
+)
+
+func TestACL(t *testing.T) {
+	acl := ACL{
+		{Roles: []string{"contractor"}, Groups: []string{"!lists.internal-*", "lists.*"}},
+		{Roles: []string{"contractor", RoleAnonymous}, Groups: []string{"*"}, Deny: true},
+		{Users: []string{"bob"}, Groups: []string{"lists.internal-hr"}, Deny: true},
+		{Groups: []string{"*"}},
+	}
+
+	contractor := &storage.User{Name: "carol", Role: "contractor"}
+	staff := &storage.User{Name: "alice"}
+	bob := &storage.User{Name: "bob"}
+
+	for _, tc := range []struct {
+		user    *storage.User
+		group   string
+		allowed bool
+	}{
+		{contractor, "lists.public", true},
+		{contractor, "lists.internal-dev", false},
+		{contractor, "rss.golang.blog", false},
+		{nil, "lists.public", false},
+		{staff, "lists.internal-dev", true},
+		{staff, "lists.internal-hr", true},
+		{bob, "lists.internal-hr", false},
+		{bob, "lists.internal-dev", true},
+	} {
+		if got := acl.Allowed(tc.user, tc.group); got != tc.allowed {
+			t.Errorf("Allowed(%v, %s) = %v, expected %v",
+				tc.user, tc.group, got, tc.allowed)
+		}
+	}
+
+	if !(ACL{}).Allowed(nil, "any.group") {
+		t.Errorf("Empty ACL denied access")
+	}
+	if (ACL{{Roles: []string{"staff"}, Groups: []string{"*"}}}).Allowed(staff, "any.group") {
+		t.Errorf("Unmatched group was allowed")
+	}
+
+	post := ACL{
+		{Roles: []string{"staff"}, Groups: []string{"lists.*"}, Post: true},
+		{Groups: []string{"*"}},
+	}
+	staff.Role = "staff"
+	if !post.CanPost(staff) || !post.PostAllowed(staff, "lists.dev") {
+		t.Errorf("Staff may not post")
+	}
+	if post.PostAllowed(staff, "rss.golang.blog") || post.CanPost(bob) {
+		t.Errorf("Posting allowed without a post rule")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	if err := storage.Open(storage.Config{DSN: ":memory:"}); err != nil {
+		t.Fatalf("Error opening storage: %v", err)
+	}
+	defer storage.Close()
+
+	if _, err := storage.CreateUser(storage.GetDb(), "alice", "secret", false); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+
+	acl := ACL{{Users: []string{"alice"}, Groups: []string{"*"}, Post: true}}
+	posters := map[string]Poster{"usenet": &testPoster{}}
+	o := &Operator{acl: acl, posters: posters}
+
+	if _, err := o.Authenticate("alice", "wrong"); !errors.Is(err, nntp_sv.ErrAuthRejected) {
+		t.Errorf("Expected ErrAuthRejected, got %v", err)
+	}
+
+	authed, err := o.Authenticate("alice", "secret")
+	if err != nil {
+		t.Fatalf("Error authenticating: %v", err)
+	}
+
+	// the session gets an operator of its own, other sessions stay anonymous
+	if o.Authorized() || o.user != nil {
+		t.Errorf("Authenticating changed the shared operator")
+	}
+	a, ok := authed.(*Operator)
+	if !ok || !a.Authorized() || a.user == nil || a.user.Name != "alice" {
+		t.Fatalf("Unexpected operator: %+v", authed)
+	}
+	if len(a.acl) != 1 || !a.acl.CanPost(a.user) || a.posters["usenet"] != posters["usenet"] {
+		t.Errorf("Operator lost its ACL or posters: %+v", a)
+	}
+}
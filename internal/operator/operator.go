@@ -1,34 +1,72 @@
 package operator
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/textproto"
 	nntp_sv "newsmere/internal/service/nntp"
 	"newsmere/internal/storage"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"gorm.io/gorm"
 )
 
-func New() *Operator {
+// xrefHost names this server in Xref headers.
+var xrefHost = hostname()
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "newsmere"
+	}
+	return name
+}
+
+// New creates the operator for anonymous sessions. Posted articles are
+// forwarded to the poster named like the source of their groups.
+func New(config json.RawMessage, posters map[string]Poster) (*Operator, error) {
+	var c Config
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+
 	return &Operator{
 		authorized: false,
-	}
+		acl:        c.ACL,
+		posters:    posters,
+	}, nil
+}
+
+// allowed reports whether the session may access the group.
+func (o *Operator) allowed(group *storage.Group) bool {
+	return o.acl.Allowed(o.user, group.Source+"."+group.Name)
 }
 
 func (o *Operator) ListGroups(max int) ([]*storage.Group, error) {
 	db := storage.GetDb()
 
 	var groups []*storage.Group
-	if max < 0 {
-		result := db.Find(&groups)
-		if result.Error != nil {
-			return nil, result.Error
+	result := db.Where("enabled = ?", true).Find(&groups)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	visible := groups[:0]
+	for _, g := range groups {
+		if max >= 0 && len(visible) >= max {
+			break
 		}
-	} else {
-		result := db.Limit(max).Find(&groups)
-		if result.Error != nil {
-			return nil, result.Error
+		if o.allowed(g) {
+			visible = append(visible, g)
 		}
 	}
 
-	return groups, nil
+	return visible, nil
 }
 
 func (o *Operator) GetGroup(name string) (*storage.Group, error) {
@@ -45,22 +83,88 @@ func (o *Operator) GetGroup(name string) (*storage.Group, error) {
 	db := storage.GetDb()
 
 	var group *storage.Group
-	result := db.Where("name = ? AND source = ?", parts[1], parts[0]).First(&group)
+	result := db.Where("name = ? AND source = ? AND enabled = ?",
+		parts[1], parts[0], true).Limit(1).Find(&group)
 	if result.Error != nil {
 		return nil, result.Error
 	}
+	// hidden groups are reported like missing ones
+	if result.RowsAffected == 0 || !o.allowed(group) {
+		return nil, nntp_sv.ErrNoSuchGroup
+	}
 
 	return group, nil
 }
 
+// GetArticle looks up an article either by its <message-id> or by its
+// number in the given group.
 func (o *Operator) GetArticle(group *nntp_sv.Group, id string) (
-	*nntp_sv.Article, error) {
-	return nil, nil
+	*nntp_sv.NumberedArticle, error) {
+	db := storage.GetDb()
+
+	if strings.HasPrefix(id, "<") {
+		var article storage.Article
+		result := db.Preload("Groups").Where("msg_id = ?", id).
+			Limit(1).Find(&article)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil, nntp_sv.ErrInvalidMessageID
+		}
+
+		// the article may be in several groups, any visible one will do
+		groups, err := o.visibleGroups([]*storage.Article{&article})
+		if err != nil {
+			return nil, err
+		}
+		if len(groups) == 0 {
+			return nil, nntp_sv.ErrInvalidMessageID
+		}
+		return toNumbered(&article, 0, groups)
+	}
+
+	num, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, nntp_sv.ErrInvalidArticleNumber
+	}
+
+	g, err := o.GetGroup(group.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var link storage.GroupArticle
+	result := db.Preload("Article.Groups").
+		Where("group_id = ? AND number = ?", g.ID, num).Limit(1).Find(&link)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nntp_sv.ErrInvalidArticleNumber
+	}
+
+	return o.numbered([]storage.GroupArticle{link})
 }
 
 func (o *Operator) GetArticles(group *nntp_sv.Group, from, to int64) (
 	[]nntp_sv.NumberedArticle, error) {
-	return nil, nil
+	g, err := o.GetGroup(group.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	db := storage.GetDb()
+
+	var links []storage.GroupArticle
+	result := db.Preload("Article.Groups").
+		Where("group_id = ? AND number BETWEEN ? AND ?", g.ID, from, to).
+		Order("number").Find(&links)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return o.numberedAll(links)
 }
 
 func (o *Operator) Authorized() bool {
@@ -68,6 +172,216 @@ func (o *Operator) Authorized() bool {
 }
 
 func (o *Operator) Authenticate(user, pass string) (nntp_sv.Operator, error) {
-	o.authorized = true
-	return o, nil
+	u, err := storage.CheckUser(storage.GetDb(), user, pass)
+	if errors.Is(err, storage.ErrInvalidCredentials) {
+		return nil, nntp_sv.ErrAuthRejected
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Operator{
+		authorized: true,
+		user:       u,
+		acl:        o.acl,
+		posters:    o.posters,
+	}, nil
+}
+
+// numbered converts the single article of links.
+func (o *Operator) numbered(links []storage.GroupArticle) (
+	*nntp_sv.NumberedArticle, error) {
+	numbered, err := o.numberedAll(links)
+	if err != nil {
+		return nil, err
+	}
+	return &numbered[0], nil
+}
+
+// numberedAll converts the articles of links, which have to be loaded with
+// their groups.
+func (o *Operator) numberedAll(links []storage.GroupArticle) (
+	[]nntp_sv.NumberedArticle, error) {
+	articles := make([]*storage.Article, len(links))
+	for i := range links {
+		articles[i] = &links[i].Article
+	}
+	groups, err := o.visibleGroups(articles)
+	if err != nil {
+		return nil, err
+	}
+
+	numbered := make([]nntp_sv.NumberedArticle, 0, len(links))
+	for i := range links {
+		a, err := toNumbered(articles[i], int64(links[i].Number), groups)
+		if err != nil {
+			return nil, err
+		}
+		numbered = append(numbered, *a)
+	}
+	return numbered, nil
+}
+
+// visibleGroups returns the groups of the articles the session may access,
+// by id.
+func (o *Operator) visibleGroups(articles []*storage.Article) (
+	map[uint]*storage.Group, error) {
+	var ids []uint
+	for _, a := range articles {
+		for _, l := range a.Groups {
+			ids = append(ids, l.GroupId)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var groups []*storage.Group
+	result := storage.GetDb().Where("id IN ? AND enabled = ?", ids, true).
+		Find(&groups)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	visible := make(map[uint]*storage.Group, len(groups))
+	for _, g := range groups {
+		if o.allowed(g) {
+			visible[g.ID] = g
+		}
+	}
+	return visible, nil
+}
+
+// toNumbered converts a stored article, the body is not loaded until it
+// is read.
+func toNumbered(a *storage.Article, num int64, groups map[uint]*storage.Group) (
+	*nntp_sv.NumberedArticle, error) {
+	header := textproto.MIMEHeader{}
+	if len(a.Headers) > 0 {
+		if err := json.Unmarshal(a.Headers, &header); err != nil {
+			return nil, err
+		}
+	}
+
+	// the Xref of the source refers to its numbers
+	header.Del("Xref")
+	if xref := xref(a, groups); xref != "" {
+		header.Set("Xref", xref)
+	}
+
+	return &nntp_sv.NumberedArticle{
+		Num: num,
+		Article: &nntp_sv.Article{
+			Header: header,
+			Body:   storage.OpenBody(storage.GetDb(), a.ID),
+			Bytes:  a.Bytes,
+			Lines:  a.Lines,
+		},
+	}, nil
+}
+
+// xref lists the numbers of a cross-posted article in the visible groups,
+// so that readers can mark it read in all of them. It is empty for
+// articles in a single group.
+func xref(a *storage.Article, groups map[uint]*storage.Group) string {
+	var entries []string
+	for _, l := range a.Groups {
+		if g, ok := groups[l.GroupId]; ok {
+			entries = append(entries,
+				fmt.Sprintf("%s.%s:%d", g.Source, g.Name, l.Number))
+		}
+	}
+	if len(entries) < 2 {
+		return ""
+	}
+	sort.Strings(entries)
+	return xrefHost + " " + strings.Join(entries, " ")
+}
+
+func (o *Operator) ArticleNumbers(group *nntp_sv.Group, from, to int64) (
+	[]int64, error) {
+	g, err := o.GetGroup(group.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var numbers []int64
+	result := storage.GetDb().Model(&storage.GroupArticle{}).
+		Where("group_id = ? AND number BETWEEN ? AND ?", g.ID, from, to).
+		Order("number").Pluck("number", &numbers)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return numbers, nil
+}
+
+func (o *Operator) AdjacentArticle(group *nntp_sv.Group, num int64, next bool) (
+	*nntp_sv.NumberedArticle, error) {
+	g, err := o.GetGroup(group.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	query := storage.GetDb().Preload("Article.Groups").Where("group_id = ?", g.ID)
+	if next {
+		query = query.Where("number > ?", num).Order("number")
+	} else {
+		query = query.Where("number < ?", num).Order("number DESC")
+	}
+
+	var link storage.GroupArticle
+	result := query.Limit(1).Find(&link)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		if next {
+			return nil, nntp_sv.ErrNoNextArticle
+		}
+		return nil, nntp_sv.ErrNoPreviousArticle
+	}
+
+	return o.numbered([]storage.GroupArticle{link})
+}
+
+// NewNews returns the articles of the visible groups stored since the
+// given time.
+func (o *Operator) NewNews(since time.Time) ([]nntp_sv.NewsItem, error) {
+	groups, err := o.ListGroups(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[uint]string, len(groups))
+	ids := make([]uint, 0, len(groups))
+	for _, g := range groups {
+		names[g.ID] = g.Source + "." + g.Name
+		ids = append(ids, g.ID)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	// articles count as new in a group from when they were added to it
+	var links []storage.GroupArticle
+	result := storage.GetDb().
+		Preload("Article", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id", "msg_id")
+		}).
+		Where("group_id IN ? AND created_at >= ?", ids, since).
+		Order("created_at").Find(&links)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	items := make([]nntp_sv.NewsItem, 0, len(links))
+	for _, l := range links {
+		items = append(items, nntp_sv.NewsItem{
+			Group:     names[l.GroupId],
+			MessageID: l.Article.MsgID,
+		})
+	}
+
+	return items, nil
 }
@@ -0,0 +1,118 @@
+package operator
+
+import (
+	"bytes"
+	"fmt"
+	nntp_sv "newsmere/internal/service/nntp"
+	"newsmere/internal/storage"
+	"testing"
+	"time"
+)
+
+func TestArticleNavigation(t *testing.T) {
+	if err := storage.Open(storage.Config{DSN: ":memory:"}); err != nil {
+		t.Fatalf("Error opening storage: %v", err)
+	}
+	defer storage.Close()
+
+	db := storage.GetDb()
+	group := storage.Group{Name: "go.nuts", Source: "usenet"}
+	db.Create(&group)
+	hidden := storage.Group{Name: "internal", Source: "lists"}
+	db.Create(&hidden)
+
+	for i, g := range []*storage.Group{&group, &group, &group, &hidden} {
+		article := storage.Article{MsgID: fmt.Sprintf("<%d@test>", i)}
+		err := storage.SaveArticle(db, &article, []uint{g.ID}, bytes.NewReader(nil))
+		if err != nil {
+			t.Fatalf("Error saving article: %v", err)
+		}
+	}
+
+	o := &Operator{acl: ACL{{Groups: []string{"usenet.*"}}}}
+	g := &nntp_sv.Group{Name: "usenet.go.nuts"}
+
+	numbers, err := o.ArticleNumbers(g, 2, 10)
+	if err != nil || fmt.Sprint(numbers) != "[2 3]" {
+		t.Errorf("Unexpected numbers: %v, %v", numbers, err)
+	}
+
+	next, err := o.AdjacentArticle(g, 1, true)
+	if err != nil || next.Num != 2 {
+		t.Errorf("Unexpected next article: %v, %v", next, err)
+	}
+	if _, err := o.AdjacentArticle(g, 3, true); err != nntp_sv.ErrNoNextArticle {
+		t.Errorf("Expected ErrNoNextArticle, got %v", err)
+	}
+	if _, err := o.AdjacentArticle(g, 1, false); err != nntp_sv.ErrNoPreviousArticle {
+		t.Errorf("Expected ErrNoPreviousArticle, got %v", err)
+	}
+
+	items, err := o.NewNews(time.Now().Add(-time.Hour))
+	if err != nil || len(items) != 3 {
+		t.Fatalf("Unexpected new articles: %v, %v", items, err)
+	}
+	if items[0].Group != "usenet.go.nuts" || items[0].MessageID != "<0@test>" {
+		t.Errorf("Unexpected item: %+v", items[0])
+	}
+	if items, _ := o.NewNews(time.Now().Add(time.Hour)); len(items) != 0 {
+		t.Errorf("Unexpected new articles: %v", items)
+	}
+}
+
+func TestCrossPostXref(t *testing.T) {
+	if err := storage.Open(storage.Config{DSN: ":memory:"}); err != nil {
+		t.Fatalf("Error opening storage: %v", err)
+	}
+	defer storage.Close()
+
+	db := storage.GetDb()
+	nuts := storage.Group{Name: "go.nuts", Source: "usenet"}
+	misc := storage.Group{Name: "go.misc", Source: "usenet"}
+	hidden := storage.Group{Name: "internal", Source: "lists"}
+	for _, g := range []*storage.Group{&nuts, &misc, &hidden} {
+		db.Create(g)
+	}
+
+	headers := []byte(`{"Subject":["hello"],"Xref":["remote go.nuts:4711"]}`)
+	for _, a := range []struct {
+		id     string
+		groups []uint
+	}{
+		{"<1@test>", []uint{misc.ID}},
+		{"<2@test>", []uint{nuts.ID, misc.ID, hidden.ID}},
+		{"<3@test>", []uint{nuts.ID}},
+	} {
+		article := storage.Article{MsgID: a.id, Headers: headers}
+		err := storage.SaveArticle(db, &article, a.groups, bytes.NewReader(nil))
+		if err != nil {
+			t.Fatalf("Error saving article: %v", err)
+		}
+	}
+
+	o := &Operator{acl: ACL{{Groups: []string{"usenet.*"}}}}
+	g := &nntp_sv.Group{Name: "usenet.go.nuts"}
+
+	articles, err := o.GetArticles(g, 1, 10)
+	if err != nil || len(articles) != 2 {
+		t.Fatalf("Unexpected articles: %v, %v", articles, err)
+	}
+	want := xrefHost + " usenet.go.misc:2 usenet.go.nuts:1"
+	if xref := articles[0].Article.Header.Get("Xref"); xref != want {
+		t.Errorf("Unexpected Xref: %q", xref)
+	}
+	if xref := articles[1].Article.Header.Get("Xref"); xref != "" {
+		t.Errorf("Unexpected Xref of a single group: %q", xref)
+	}
+
+	a, err := o.GetArticle(g, "<2@test>")
+	if err != nil || a.Article.Header.Get("Xref") != want {
+		t.Errorf("Unexpected article: %v, %v", a, err)
+	}
+
+	// articles only in groups the session can't see are not found
+	o = &Operator{acl: ACL{{Groups: []string{"usenet.go.misc"}}}}
+	if _, err := o.GetArticle(g, "<3@test>"); err != nntp_sv.ErrInvalidMessageID {
+		t.Errorf("Expected ErrInvalidMessageID, got %v", err)
+	}
+}
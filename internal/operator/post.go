@@ -0,0 +1,156 @@
+package operator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/mail"
+	"net/textproto"
+	nntp_sv "newsmere/internal/service/nntp"
+	"newsmere/internal/storage"
+	"sort"
+	"strings"
+)
+
+// CanPost reports whether the session may post to any group.
+func (o *Operator) CanPost() bool {
+	return o.acl.CanPost(o.user)
+}
+
+// Post forwards the article to the sources of its groups, and stores it
+// into the groups once every source took it.
+func (o *Operator) Post(article *nntp_sv.Article) error {
+	groups, err := o.postGroups(article.Header.Get("Newsgroups"))
+	if err != nil {
+		return err
+	}
+
+	body, err := io.ReadAll(article.Body)
+	if err != nil {
+		return err
+	}
+
+	// the groups of each source, by their remote names
+	var sources []string
+	names := make(map[string][]string)
+	for _, g := range groups {
+		if _, ok := names[g.Source]; !ok {
+			sources = append(sources, g.Source)
+		}
+		names[g.Source] = append(names[g.Source], g.Name)
+	}
+
+	for _, source := range sources {
+		header := make(textproto.MIMEHeader, len(article.Header))
+		for k, vs := range article.Header {
+			header[k] = vs
+		}
+		header.Set("Newsgroups", strings.Join(names[source], ","))
+
+		if err := o.posters[source].Post(formatArticle(header, body)); err != nil {
+			log.Printf("[Operator] forwarding article to %s failed: %v",
+				source, err)
+			return nntp_sv.ErrPostingFailed
+		}
+	}
+
+	if err := storePosted(groups, article.Header, body); err != nil {
+		// the sources took the article, it is stored with the next sync
+		log.Printf("[Operator] storing posted article failed: %v", err)
+	}
+	return nil
+}
+
+// postGroups resolves the groups of a Newsgroups header, all of them have
+// to accept posts from the session.
+func (o *Operator) postGroups(newsgroups string) ([]*storage.Group, error) {
+	var groups []*storage.Group
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(newsgroups, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		g, err := o.GetGroup(name)
+		if errors.Is(err, nntp_sv.ErrNoSuchGroup) {
+			return nil, postingFailed("No such newsgroup %s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !o.acl.PostAllowed(o.user, name) {
+			return nil, postingFailed("Posting to %s not permitted", name)
+		}
+		if _, ok := o.posters[g.Source]; !ok {
+			return nil, postingFailed("Posting to %s not supported", name)
+		}
+		groups = append(groups, g)
+	}
+
+	if len(groups) == 0 {
+		return nil, postingFailed("No newsgroups given")
+	}
+	return groups, nil
+}
+
+func postingFailed(format string, args ...interface{}) error {
+	return &nntp_sv.NNTPError{
+		Code: nntp_sv.ErrPostingFailed.Code,
+		Msg:  fmt.Sprintf(format, args...),
+	}
+}
+
+// formatArticle joins the header and the body of an article.
+func formatArticle(header textproto.MIMEHeader, body []byte) io.Reader {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		for _, v := range header[k] {
+			fmt.Fprintf(&buf, "%s: %s\n", k, v)
+		}
+	}
+	buf.WriteString("\n")
+	buf.Write(body)
+
+	return &buf
+}
+
+func storePosted(groups []*storage.Group, header textproto.MIMEHeader,
+	body []byte) error {
+	headers, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	date, _ := mail.Header(header).Date()
+
+	ids := make([]uint, 0, len(groups))
+	for _, g := range groups {
+		ids = append(ids, g.ID)
+	}
+
+	// a cross-post is stored once, numbered in each group
+	article := storage.Article{
+		MsgID:      header.Get("Message-Id"),
+		DocType:    nntp_sv.Type,
+		Title:      header.Get("Subject"),
+		Author:     header.Get("From"),
+		PostedAt:   date,
+		References: header.Get("References"),
+		Headers:    headers,
+	}
+	err = storage.SaveArticle(storage.GetDb(), &article, ids, bytes.NewReader(body))
+	if err != nil && !errors.Is(err, storage.ErrArticleExists) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package operator
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/textproto"
+	nntp_sv "newsmere/internal/service/nntp"
+	"newsmere/internal/storage"
+	"strings"
+	"testing"
+)
+
+type testPoster struct {
+	posted []string
+	err    error
+}
+
+func (p *testPoster) Post(article io.Reader) error {
+	b, _ := io.ReadAll(article)
+	p.posted = append(p.posted, string(b))
+	return p.err
+}
+
+func TestPost(t *testing.T) {
+	if err := storage.Open(storage.Config{DSN: ":memory:"}); err != nil {
+		t.Fatalf("Error opening storage: %v", err)
+	}
+	defer storage.Close()
+
+	db := storage.GetDb()
+	for _, g := range []storage.Group{
+		{Name: "go.nuts", Source: "usenet"},
+		{Name: "golang.blog", Source: "feeds"},
+	} {
+		db.Create(&g)
+	}
+
+	poster := &testPoster{}
+	o := &Operator{
+		authorized: true,
+		user:       &storage.User{Name: "alice"},
+		posters:    map[string]Poster{"usenet": poster},
+	}
+
+	article := func(groups string) *nntp_sv.Article {
+		return &nntp_sv.Article{
+			Header: textproto.MIMEHeader{
+				"Message-Id": {"<1@test>"},
+				"From":       {"alice@example.com"},
+				"Subject":    {"test"},
+				"Newsgroups": {groups},
+			},
+			Body: strings.NewReader("body\n"),
+		}
+	}
+
+	if err := o.Post(article("usenet.go.nuts")); err != nil {
+		t.Fatalf("Error posting: %v", err)
+	}
+	if len(poster.posted) != 1 ||
+		!strings.Contains(poster.posted[0], "Newsgroups: go.nuts\n") ||
+		!strings.HasSuffix(poster.posted[0], "\n\nbody\n") {
+		t.Errorf("Unexpected forwarded articles: %q", poster.posted)
+	}
+
+	stored, err := o.GetArticle(&nntp_sv.Group{Name: "usenet.go.nuts"}, "<1@test>")
+	if err != nil {
+		t.Fatalf("Posted article not stored: %v", err)
+	}
+	body, _ := io.ReadAll(stored.Article.Body)
+	if !bytes.Equal(body, []byte("body\n")) {
+		t.Errorf("Unexpected body: %q", body)
+	}
+
+	var nntpErr *nntp_sv.NNTPError
+	for _, groups := range []string{"feeds.golang.blog", "usenet.missing", ""} {
+		err := o.Post(article(groups))
+		if !errors.As(err, &nntpErr) || nntpErr.Code != 441 {
+			t.Errorf("Expected 441 posting to %q, got %v", groups, err)
+		}
+	}
+
+	poster.err = errors.New("rejected")
+	if err := o.Post(article("usenet.go.nuts")); err != nntp_sv.ErrPostingFailed {
+		t.Errorf("Expected ErrPostingFailed, got %v", err)
+	}
+
+	anonymous := &Operator{posters: o.posters}
+	if anonymous.CanPost() {
+		t.Errorf("Anonymous session may post")
+	}
+	if err := anonymous.Post(article("usenet.go.nuts")); !errors.As(err, &nntpErr) {
+		t.Errorf("Anonymous post was accepted: %v", err)
+	}
+}
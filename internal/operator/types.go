@@ -1,5 +1,25 @@
 package operator
 
+import (
+	"io"
+	"newsmere/internal/storage"
+)
+
 type Operator struct {
 	authorized bool
+	user       *storage.User
+	acl        ACL
+	posters    map[string]Poster
+}
+
+// Config is read from the configuration of the service using the
+// operator.
+type Config struct {
+	ACL ACL `json:"acl"`
+}
+
+// Poster is a backend that takes articles posted to its groups.
+type Poster interface {
+	// Post sends an article, headers and body separated by an empty line.
+	Post(article io.Reader) error
 }
@@ -1,10 +1,22 @@
 package nntp
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"newsmere/internal/types"
+	"time"
+)
+
+const (
+	defaultShutdownTimeout = 10
+	// RFC 3977 asks for at least three minutes
+	defaultIdleTimeout    = 10 * 60
+	defaultCommandTimeout = 5 * 60
+	defaultPort           = 119
+	defaultTLSPort        = 563
 )
 
 // MessageID provides convenient access to the article's Message ID.
@@ -19,7 +31,7 @@ func New(config json.RawMessage, operator Operator) (*Service, error) {
 	return service, err
 }
 
-func (Service) Type() string {
+func (*Service) Type() string {
 	return Type
 }
 
@@ -29,37 +41,131 @@ func (s *Service) Start() error {
 		host = "127.0.0.1"
 	}
 
-	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", host, s.Port))
+	tlsConfig, err := s.tlsConfig()
 	if err != nil {
 		return err
 	}
 
-	listener, err := net.ListenTCP("tcp", addr)
+	port := s.Port
+	if port == 0 && s.TLS {
+		port = defaultTLSPort
+	} else if port == 0 {
+		port = defaultPort
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", host, port))
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("[Service] %s listen at: %s:%d\n", s.Type(), host, s.Port)
+	var listener net.Listener
+	listener, err = net.ListenTCP("tcp", addr)
+	if err != nil {
+		return err
+	}
 
-	s.server = NewServer(s.operator)
+	server := NewServer(s.operator)
+	server.RequireAuth = s.RequireAuth
+	server.RequireTLSAuth = s.RequireTLSAuth
+	server.IdleTimeout = seconds(s.IdleTimeout, defaultIdleTimeout)
+	server.CommandTimeout = seconds(s.CommandTimeout, defaultCommandTimeout)
+	if s.TLS {
+		listener = tls.NewListener(listener, tlsConfig)
+		fmt.Printf("[Service] %s listen with TLS at: %s:%d\n", s.Type(), host, port)
+	} else {
+		server.TLSConfig = tlsConfig
+		fmt.Printf("[Service] %s listen at: %s:%d\n", s.Type(), host, port)
+	}
+
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return listener.Close()
+	}
+	s.listener = listener
+	s.server = server
+	s.mu.Unlock()
 
 	for {
-		c, err := listener.AcceptTCP()
+		c, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			// stopped
+			return nil
+		}
 		if err != nil {
 			return err
 		}
-		go s.server.Process(c)
+		go server.Process(c)
+	}
+}
+
+// tlsConfig loads the certificate, it returns nil if none is configured.
+func (s *Service) tlsConfig() (*tls.Config, error) {
+	if s.TLSCert == "" && s.TLSKey == "" {
+		if s.TLS {
+			return nil, errors.New("tls requires tls_cert and tls_key")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.TLSCert, s.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
 	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
 }
 
+// Stop closes the listener and waits for the running sessions to end.
 func (s *Service) Stop() error {
-	return nil
+	s.mu.Lock()
+	listener, server := s.listener, s.server
+	s.listener = nil
+	s.stopped = true
+	s.mu.Unlock()
+
+	if listener == nil {
+		return nil
+	}
+
+	err := listener.Close()
+
+	server.Shutdown(seconds(s.ShutdownTimeout, defaultShutdownTimeout))
+
+	return err
 }
 
 func (s *Service) Restart() error {
-	return nil
+	if err := s.Stop(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.stopped = false
+	s.mu.Unlock()
+
+	return s.Start()
 }
 
 func (s *Service) Status() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		return types.StatusDown
+	}
+
 	return types.StatusUp
 }
+
+// seconds converts a configured number of seconds, def applies if it is
+// not set.
+func seconds(n, def int) time.Duration {
+	if n <= 0 {
+		n = def
+	}
+	return time.Duration(n) * time.Second
+}
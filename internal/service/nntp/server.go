@@ -1,20 +1,33 @@
 package nntp
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"net"
+	"net/mail"
 	"net/textproto"
+	"newsmere/internal/storage"
+	"newsmere/internal/wildmat"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// maxPostSize bounds the body of posted articles.
+const maxPostSize = 1 << 20
+
 func NewServer(operator Operator) *NNTPServer {
 	rv := NNTPServer{
 		Handlers: make(map[string]Handler),
 		Operator: operator,
+		conns:    make(map[net.Conn]bool),
 	}
 	rv.Handlers[""] = handleDefault
 	rv.Handlers["quit"] = handleQuit
@@ -29,6 +42,17 @@ func NewServer(operator Operator) *NNTPServer {
 	rv.Handlers["newgroups"] = handleNewGroups
 	rv.Handlers["over"] = handleOver
 	rv.Handlers["xover"] = handleOver
+	rv.Handlers["post"] = handlePost
+	rv.Handlers["stat"] = handleStat
+	rv.Handlers["next"] = handleNext
+	rv.Handlers["last"] = handleLast
+	rv.Handlers["listgroup"] = handleListGroup
+	rv.Handlers["date"] = handleDate
+	rv.Handlers["help"] = handleHelp
+	rv.Handlers["hdr"] = handleHdr
+	rv.Handlers["xhdr"] = handleXHdr
+	rv.Handlers["newnews"] = handleNewNews
+	rv.Handlers["starttls"] = handleStartTLS
 
 	return &rv
 }
@@ -37,8 +61,23 @@ func (e *NNTPError) Error() string {
 	return fmt.Sprintf("%d %s", e.Code, e.Msg)
 }
 
+// unauthenticatedCommands may be used before authenticating when the
+// server requires authentication.
+var unauthenticatedCommands = map[string]bool{
+	"capabilities": true,
+	"authinfo":     true,
+	"starttls":     true,
+	"quit":         true,
+}
+
 func (s *session) dispatchCommand(cmd string, args []string, c *textproto.Conn) (err error) {
-	handler, found := s.server.Handlers[strings.ToLower(cmd)]
+	cmd = strings.ToLower(cmd)
+	if s.server.RequireAuth && !s.operator.Authorized() &&
+		!unauthenticatedCommands[cmd] {
+		return ErrNotAuthenticated
+	}
+
+	handler, found := s.server.Handlers[cmd]
 	if !found {
 		handler, found = s.server.Handlers[""]
 		if !found {
@@ -53,21 +92,73 @@ func (s *NNTPServer) Process(nc net.Conn) {
 	defer nc.Close()
 	c := textproto.NewConn(nc)
 
+	if !s.track(nc) {
+		c.PrintfLine(ErrShuttingDown.Error())
+		return
+	}
+	defer s.untrack(nc)
+
+	// every session starts out anonymous, AUTHINFO replaces the operator
+	// of the session only
 	sess := &session{
 		server:   s,
 		operator: s.Operator,
 		group:    nil,
+		conn:     c,
+		netConn:  nc,
+	}
+	// the greeting, and the handshake of implicit TLS run under it, must
+	// not wait for a silent client forever
+	nc.SetDeadline(deadline(s.CommandTimeout))
+	if tc, ok := nc.(*tls.Conn); ok {
+		sess.tls = true
+
+		// the handshake waits for the client, a shutdown ends it at once
+		if !s.setIdle(nc, true) {
+			return
+		}
+		err := tc.Handshake()
+		s.setIdle(nc, false)
+		if err != nil {
+			log.Printf("Error in TLS handshake, dropping conn: %v", err)
+			return
+		}
 	}
 
-	c.PrintfLine("200 Hello!")
+	if sess.operator.CanPost() {
+		c.PrintfLine("200 Hello, posting allowed")
+	} else {
+		c.PrintfLine("201 Hello, posting prohibited")
+	}
 	for {
+		// STARTTLS replaces the connection of the session
+		c := sess.conn
+
+		// a shutdown cuts the idle deadline short, so it is set before the
+		// session is marked idle
+		nc.SetReadDeadline(deadline(s.IdleTimeout))
+		if !s.setIdle(nc, true) {
+			c.PrintfLine(ErrShuttingDown.Error())
+			return
+		}
 		l, err := c.ReadLine()
+		s.setIdle(nc, false)
+		nc.SetDeadline(deadline(s.CommandTimeout))
 		if err != nil {
+			if s.isClosing() {
+				c.PrintfLine(ErrShuttingDown.Error())
+				return
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				c.PrintfLine(ErrIdleTimeout.Error())
+				return
+			}
 			log.Printf("Error reading from client, dropping conn: %v", err)
 			return
 		}
 		cmd := strings.Split(l, " ")
-		log.Printf("Got cmd: %+v", cmd)
+		log.Printf("Got cmd: %+v", redact(cmd))
 		args := []string{}
 		if len(cmd) > 1 {
 			args = cmd[1:]
@@ -89,17 +180,102 @@ func (s *NNTPServer) Process(nc net.Conn) {
 	}
 }
 
+// Shutdown ends all sessions and returns once they did. Sessions waiting
+// for a command are ended at once, the others after their current command
+// or, when the timeout expires, by closing their connections.
+func (s *NNTPServer) Shutdown(timeout time.Duration) {
+	s.mu.Lock()
+	s.closing = true
+	for nc, idle := range s.conns {
+		if idle {
+			nc.SetReadDeadline(time.Now())
+		}
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		s.mu.Lock()
+		for nc := range s.conns {
+			nc.Close()
+		}
+		s.mu.Unlock()
+
+		// a command in progress fails on its next read or write, the
+		// storage must not be closed under it
+		<-done
+	}
+}
+
+// track registers a new session, unless the server is shutting down.
+func (s *NNTPServer) track(nc net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return false
+	}
+	s.conns[nc] = false
+	s.wg.Add(1)
+	return true
+}
+
+func (s *NNTPServer) untrack(nc net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, nc)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+// setIdle marks whether the session waits for a command, it reports false
+// when an idle session should end because of a shutdown.
+func (s *NNTPServer) setIdle(nc net.Conn, idle bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conns[nc] = idle
+	return !(idle && s.closing)
+}
+
+// redact hides the password of AUTHINFO PASS from the log.
+func redact(cmd []string) []string {
+	if len(cmd) > 2 && strings.EqualFold(cmd[0], "authinfo") &&
+		strings.EqualFold(cmd[1], "pass") {
+		return []string{cmd[0], cmd[1], "********"}
+	}
+	return cmd
+}
+
+// deadline returns the deadline of a timeout, none if it is zero.
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+func (s *NNTPServer) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closing
+}
+
 func parseRange(spec string) (low, high int64) {
 	if spec == "" {
 		return 0, math.MaxInt64
 	}
 	parts := strings.Split(spec, "-")
 	if len(parts) == 1 {
-		h, err := strconv.ParseInt(parts[0], 10, 64)
-		if err != nil {
-			h = math.MaxInt64
-		}
-		return 0, h
+		n, _ := strconv.ParseInt(parts[0], 10, 64)
+		return n, n
 	}
 	l, _ := strconv.ParseInt(parts[0], 10, 64)
 	h, err := strconv.ParseInt(parts[1], 10, 64)
@@ -109,15 +285,39 @@ func parseRange(spec string) (low, high int64) {
 	return l, h
 }
 
-func handleOver(args []string, s *session, c *textproto.Conn) error {
+// getArticles returns the articles selected by a range or message-id
+// argument, or the current article without argument.
+func (s *session) getArticles(args []string) ([]NumberedArticle, error) {
+	if len(args) > 0 && strings.HasPrefix(args[0], "<") {
+		article, err := s.operator.GetArticle(s.group, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return []NumberedArticle{*article}, nil
+	}
+
 	if s.group == nil {
-		return ErrNoGroupSelected
+		return nil, ErrNoGroupSelected
 	}
+	if len(args) < 1 {
+		if s.article == 0 {
+			return nil, ErrNoCurrentArticle
+		}
+		args = []string{strconv.FormatInt(s.article, 10)}
+	}
+
 	from, to := parseRange(args[0])
-	articles, err := s.operator.GetArticles(s.group, from, to)
+	return s.operator.GetArticles(s.group, from, to)
+}
+
+func handleOver(args []string, s *session, c *textproto.Conn) error {
+	articles, err := s.getArticles(args)
 	if err != nil {
 		return err
 	}
+	if len(articles) == 0 {
+		return ErrNoArticlesInRange
+	}
 
 	c.PrintfLine("224 here it comes")
 	dw := c.DotWriter()
@@ -157,8 +357,28 @@ func handleList(args []string, s *session, c *textproto.Conn) error {
 		ltype = strings.ToLower(args[0])
 	}
 
-	if ltype == "overview.fmt" {
+	switch ltype {
+	case "overview.fmt":
 		return handleListOverviewFmt(c)
+	case "headers":
+		// any header can be retrieved by HDR
+		c.PrintfLine("215 Field list follows")
+		dw := c.DotWriter()
+		defer dw.Close()
+		fmt.Fprintln(dw, ":\n:bytes\n:lines")
+		return nil
+	case "distrib.pats":
+		// distributions are not used
+		c.PrintfLine("215 Distribution patterns follow")
+		return c.PrintfLine(".")
+	case "active", "active.times", "newsgroups":
+	default:
+		return ErrSyntax
+	}
+
+	match := wildmat.Parse("*")
+	if len(args) > 1 {
+		match = wildmat.Parse(args[1])
 	}
 
 	groups, err := s.operator.ListGroups(-1)
@@ -169,20 +389,55 @@ func handleList(args []string, s *session, c *textproto.Conn) error {
 	dw := c.DotWriter()
 	defer dw.Close()
 	for _, g := range groups {
+		name := g.Source + "." + g.Name
+		if !match.Match(name) {
+			continue
+		}
 		switch ltype {
 		case "active":
-			fmt.Fprintf(dw, "%s.%s %d %d %v\r\n",
-				g.Source, g.Name, g.High, g.Low, g.High-g.Low)
+			fmt.Fprintf(dw, "%s %d %d %s\n", name, g.High, g.Low, s.postingStatus())
+		case "active.times":
+			fmt.Fprintf(dw, "%s %d newsmere\n", name, g.CreatedAt.Unix())
 		case "newsgroups":
-			fmt.Fprintf(dw, "%s %s\r\n", g.Name, g.Description)
+			fmt.Fprintf(dw, "%s\t%s\n", name, g.Description)
 		}
 	}
 	return nil
 }
 
+// postingStatus is the status of groups in LIST ACTIVE, "y" if the session
+// may post.
+func (s *session) postingStatus() string {
+	if s.operator.CanPost() {
+		return "y"
+	}
+	return "n"
+}
+
 func handleNewGroups(args []string, s *session, c *textproto.Conn) error {
-	c.PrintfLine("231 list of newsgroups follows")
-	c.PrintfLine(".")
+	if len(args) < 2 {
+		return ErrSyntax
+	}
+	since, err := parseDateTime(args[0], args[1], len(args) > 2 && strings.EqualFold(args[2], "GMT"))
+	if err != nil {
+		return ErrSyntax
+	}
+
+	groups, err := s.operator.ListGroups(-1)
+	if err != nil {
+		return err
+	}
+
+	c.PrintfLine("231 list of new newsgroups follows")
+	dw := c.DotWriter()
+	defer dw.Close()
+	for _, g := range groups {
+		if g.CreatedAt.Before(since) {
+			continue
+		}
+		fmt.Fprintf(dw, "%s.%s %d %d %s\n",
+			g.Source, g.Name, g.High, g.Low, s.postingStatus())
+	}
 	return nil
 }
 
@@ -205,23 +460,62 @@ func handleGroup(args []string, s *session, c *textproto.Conn) error {
 		return err
 	}
 
+	s.selectGroup(args[0], group)
+
+	c.PrintfLine("211 %d %d %d %s", s.group.Count, group.Low, group.High, s.group.Name)
+	return nil
+}
+
+// selectGroup makes the group the current one, its first article becomes
+// the current article.
+func (s *session) selectGroup(name string, group *storage.Group) {
 	s.group = &Group{
-		Name:        group.Name,
+		Name:        name,
 		Description: group.Description,
 		High:        int64(group.High),
 		Low:         int64(group.Low),
-		Count:       int64(group.High) - int64(group.Low),
+		Count:       int64(group.Count),
 	}
 
-	c.PrintfLine("211 %d %d %d %s", s.group.Count, group.Low, group.High, group.Name)
-	return nil
+	s.article = 0
+	if group.Count > 0 {
+		s.article = int64(group.Low)
+	}
 }
 
-func (s *session) getArticle(args []string) (*Article, error) {
+// getArticle returns the article selected by a number or message-id
+// argument, or the current article without argument. Selecting an article
+// by number makes it the current article.
+func (s *session) getArticle(args []string) (*NumberedArticle, error) {
+	if len(args) > 0 && strings.HasPrefix(args[0], "<") {
+		return s.operator.GetArticle(s.group, args[0])
+	}
+
 	if s.group == nil {
 		return nil, ErrNoGroupSelected
 	}
-	return s.operator.GetArticle(s.group, args[0])
+
+	id := strconv.FormatInt(s.article, 10)
+	if len(args) > 0 {
+		id = args[0]
+	} else if s.article == 0 {
+		return nil, ErrNoCurrentArticle
+	}
+
+	article, err := s.operator.GetArticle(s.group, id)
+	if err != nil {
+		return nil, err
+	}
+	s.article = article.Num
+	return article, nil
+}
+
+func writeHeader(w io.Writer, header textproto.MIMEHeader) {
+	for k, vs := range header {
+		for _, v := range vs {
+			fmt.Fprintf(w, "%s: %s\r\n", k, v)
+		}
+	}
 }
 
 func handleHead(args []string, s *session, c *textproto.Conn) error {
@@ -229,12 +523,10 @@ func handleHead(args []string, s *session, c *textproto.Conn) error {
 	if err != nil {
 		return err
 	}
-	c.PrintfLine("221 1 %s", article.MessageID())
+	c.PrintfLine("221 %d %s", article.Num, article.Article.MessageID())
 	dw := c.DotWriter()
 	defer dw.Close()
-	for k, v := range article.Header {
-		fmt.Fprintf(dw, "%s: %s\r\n", k, v[0])
-	}
+	writeHeader(dw, article.Article.Header)
 	return nil
 }
 
@@ -243,10 +535,10 @@ func handleBody(args []string, s *session, c *textproto.Conn) error {
 	if err != nil {
 		return err
 	}
-	c.PrintfLine("222 1 %s", article.MessageID())
+	c.PrintfLine("222 %d %s", article.Num, article.Article.MessageID())
 	dw := c.DotWriter()
 	defer dw.Close()
-	_, err = io.Copy(dw, article.Body)
+	_, err = io.Copy(dw, article.Article.Body)
 	return err
 }
 
@@ -255,17 +547,15 @@ func handleArticle(args []string, s *session, c *textproto.Conn) error {
 	if err != nil {
 		return err
 	}
-	c.PrintfLine("220 1 %s", article.MessageID())
+	c.PrintfLine("220 %d %s", article.Num, article.Article.MessageID())
 	dw := c.DotWriter()
 	defer dw.Close()
 
-	for k, v := range article.Header {
-		fmt.Fprintf(dw, "%s: %s\r\n", k, v[0])
-	}
+	writeHeader(dw, article.Article.Header)
 
 	fmt.Fprintln(dw, "")
 
-	_, err = io.Copy(dw, article.Body)
+	_, err = io.Copy(dw, article.Article.Body)
 	return err
 }
 
@@ -276,14 +566,29 @@ func handleCap(args []string, s *session, c *textproto.Conn) error {
 
 	fmt.Fprintf(dw, "VERSION 2\n")
 	fmt.Fprintf(dw, "READER\n")
-	fmt.Fprintf(dw, "OVER\n")
+	fmt.Fprintf(dw, "OVER MSGID\n")
 	fmt.Fprintf(dw, "XOVER\n")
-	fmt.Fprintf(dw, "LIST ACTIVE NEWSGROUPS OVERVIEW.FMT\n")
+	fmt.Fprintf(dw, "HDR\n")
+	fmt.Fprintf(dw, "NEWNEWS\n")
+	fmt.Fprintf(dw, "LIST ACTIVE ACTIVE.TIMES DISTRIB.PATS HEADERS NEWSGROUPS OVERVIEW.FMT\n")
+	if s.operator.CanPost() {
+		fmt.Fprintf(dw, "POST\n")
+	}
+	if s.server.TLSConfig != nil && !s.tls {
+		fmt.Fprintf(dw, "STARTTLS\n")
+	}
+	if !s.operator.Authorized() && (s.tls || !s.server.RequireTLSAuth) {
+		fmt.Fprintf(dw, "AUTHINFO USER\n")
+	}
 	return nil
 }
 
 func handleMode(args []string, s *session, c *textproto.Conn) error {
-	c.PrintfLine("201 Posting prohibited")
+	if s.operator.CanPost() {
+		c.PrintfLine("200 Posting allowed")
+	} else {
+		c.PrintfLine("201 Posting prohibited")
+	}
 	return nil
 }
 
@@ -291,30 +596,330 @@ func handleAuthInfo(args []string, s *session, c *textproto.Conn) error {
 	if len(args) < 2 {
 		return ErrSyntax
 	}
-	if strings.ToLower(args[0]) != "user" {
+	if s.operator.Authorized() {
+		return ErrCommandUnavailable
+	}
+	if s.server.RequireTLSAuth && !s.tls {
+		return ErrEncryptionRequired
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "user":
+		s.authUser = args[1]
+		return c.PrintfLine("381 Password required")
+	case "pass":
+		if s.authUser == "" {
+			return ErrAuthOutOfSequence
+		}
+		user := s.authUser
+		s.authUser = ""
+
+		b, err := s.operator.Authenticate(user, strings.Join(args[1:], " "))
+		if err != nil {
+			return err
+		}
+		s.operator = b
+		return c.PrintfLine("281 Authentication accepted")
+	default:
 		return ErrSyntax
 	}
-	if s.operator.Authorized() {
-		return c.PrintfLine("250 authenticated")
+}
+
+func handlePost(args []string, s *session, c *textproto.Conn) error {
+	if !s.operator.CanPost() {
+		return ErrPostingNotPermitted
 	}
 
-	c.PrintfLine("350 Continue")
-	a, err := c.ReadLine()
+	c.PrintfLine("340 Send article to be posted")
+
+	// the article has to be read completely even if it's rejected
+	r := c.DotReader()
+	defer io.Copy(io.Discard, r)
+
+	article, err := readPost(r)
 	if err != nil {
 		return err
 	}
 
-	parts := strings.SplitN(a, " ", 3)
-	if strings.ToLower(parts[0]) != "authinfo" || strings.ToLower(parts[1]) != "pass" {
+	if err := s.operator.Post(article); err != nil {
+		return err
+	}
+
+	c.PrintfLine("240 Article received OK")
+	return nil
+}
+
+// readPost reads and checks a posted article, missing Message-ID and Date
+// headers are added.
+func readPost(r io.Reader) (*Article, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, ErrPostingFailed
+	}
+
+	var body bytes.Buffer
+	if _, err := body.ReadFrom(io.LimitReader(msg.Body, maxPostSize+1)); err != nil {
+		return nil, ErrPostingFailed
+	}
+	if body.Len() > maxPostSize {
+		return nil, &NNTPError{ErrPostingFailed.Code, "Article too large"}
+	}
+
+	header := textproto.MIMEHeader(msg.Header)
+	for _, key := range []string{"From", "Subject", "Newsgroups"} {
+		if strings.TrimSpace(header.Get(key)) == "" {
+			return nil, &NNTPError{ErrPostingFailed.Code,
+				fmt.Sprintf("Missing %s header", key)}
+		}
+	}
+	if _, err := mail.ParseAddressList(header.Get("From")); err != nil {
+		return nil, &NNTPError{ErrPostingFailed.Code, "Invalid From header"}
+	}
+
+	if id := header.Get("Message-Id"); id == "" {
+		header.Set("Message-Id", newMessageID())
+	} else if !validMessageID(id) {
+		return nil, &NNTPError{ErrPostingFailed.Code, "Invalid Message-ID header"}
+	}
+
+	if header.Get("Date") == "" {
+		header.Set("Date", time.Now().Format(time.RFC1123Z))
+	} else if _, err := mail.Header(header).Date(); err != nil {
+		return nil, &NNTPError{ErrPostingFailed.Code, "Invalid Date header"}
+	}
+
+	return &Article{
+		Header: header,
+		Body:   &body,
+		Bytes:  body.Len(),
+		Lines:  bytes.Count(body.Bytes(), []byte("\n")),
+	}, nil
+}
+
+func validMessageID(id string) bool {
+	return len(id) > 2 && id[0] == '<' && id[len(id)-1] == '>' &&
+		strings.Contains(id, "@") && !strings.ContainsAny(id, " \t")
+}
+
+func newMessageID() string {
+	var b [12]byte
+	rand.Read(b[:])
+
+	host, err := os.Hostname()
+	if err != nil || host == "" || !strings.Contains(host, ".") {
+		host = "newsmere.invalid"
+	}
+
+	return fmt.Sprintf("<%d.%x@%s>", time.Now().Unix(), b, host)
+}
+
+func handleStat(args []string, s *session, c *textproto.Conn) error {
+	article, err := s.getArticle(args)
+	if err != nil {
+		return err
+	}
+	c.PrintfLine("223 %d %s", article.Num, article.Article.MessageID())
+	return nil
+}
+
+func handleNext(args []string, s *session, c *textproto.Conn) error {
+	return s.moveArticle(c, true)
+}
+
+func handleLast(args []string, s *session, c *textproto.Conn) error {
+	return s.moveArticle(c, false)
+}
+
+// moveArticle makes the next or previous article the current one.
+func (s *session) moveArticle(c *textproto.Conn, next bool) error {
+	if s.group == nil {
+		return ErrNoGroupSelected
+	}
+	if s.article == 0 {
+		return ErrNoCurrentArticle
+	}
+
+	article, err := s.operator.AdjacentArticle(s.group, s.article, next)
+	if err != nil {
+		return err
+	}
+	s.article = article.Num
+
+	c.PrintfLine("223 %d %s", article.Num, article.Article.MessageID())
+	return nil
+}
+
+func handleListGroup(args []string, s *session, c *textproto.Conn) error {
+	if len(args) > 0 {
+		group, err := s.operator.GetGroup(args[0])
+		if err != nil {
+			return err
+		}
+		s.selectGroup(args[0], group)
+	}
+	if s.group == nil {
+		return ErrNoGroupSelected
+	}
+
+	from, to := parseRange("")
+	if len(args) > 1 {
+		from, to = parseRange(args[1])
+	}
+	numbers, err := s.operator.ArticleNumbers(s.group, from, to)
+	if err != nil {
+		return err
+	}
+
+	c.PrintfLine("211 %d %d %d %s list follows",
+		s.group.Count, s.group.Low, s.group.High, s.group.Name)
+	dw := c.DotWriter()
+	defer dw.Close()
+	for _, n := range numbers {
+		fmt.Fprintf(dw, "%d\n", n)
+	}
+	return nil
+}
+
+func handleDate(args []string, s *session, c *textproto.Conn) error {
+	c.PrintfLine("111 %s", time.Now().UTC().Format("20060102150405"))
+	return nil
+}
+
+func handleHelp(args []string, s *session, c *textproto.Conn) error {
+	c.PrintfLine("100 Help text follows")
+	dw := c.DotWriter()
+	defer dw.Close()
+
+	fmt.Fprint(dw, `ARTICLE [message-id|number]
+AUTHINFO USER name|PASS password
+BODY [message-id|number]
+CAPABILITIES
+DATE
+GROUP newsgroup
+HDR header [message-id|range]
+HEAD [message-id|number]
+HELP
+LAST
+LIST [ACTIVE|ACTIVE.TIMES|NEWSGROUPS [wildmat]]
+LIST DISTRIB.PATS|HEADERS|OVERVIEW.FMT
+LISTGROUP [newsgroup [range]]
+MODE READER
+NEWGROUPS [yy]yymmdd hhmmss [GMT]
+NEWNEWS wildmat [yy]yymmdd hhmmss [GMT]
+NEXT
+OVER [message-id|range]
+POST
+QUIT
+STAT [message-id|number]
+XHDR header [message-id|range]
+XOVER [range]
+`)
+	return nil
+}
+
+func handleHdr(args []string, s *session, c *textproto.Conn) error {
+	return s.writeHdr(args, c, 225)
+}
+
+func handleXHdr(args []string, s *session, c *textproto.Conn) error {
+	return s.writeHdr(args, c, 221)
+}
+
+// writeHdr writes a header field, or the :bytes and :lines metadata, of
+// the selected articles.
+func (s *session) writeHdr(args []string, c *textproto.Conn, code int) error {
+	if len(args) < 1 {
 		return ErrSyntax
 	}
+	field := args[0]
 
-	b, err := s.operator.Authenticate(args[1], parts[2])
+	articles, err := s.getArticles(args[1:])
 	if err != nil {
-		c.PrintfLine("250 authenticated")
-		if b != nil {
-			s.operator = b
+		return err
+	}
+	if len(articles) == 0 {
+		return ErrNoArticlesInRange
+	}
+
+	c.PrintfLine("%d Headers follow", code)
+	dw := c.DotWriter()
+	defer dw.Close()
+	for _, a := range articles {
+		var value string
+		switch strings.ToLower(field) {
+		case ":bytes":
+			value = strconv.Itoa(a.Article.Bytes)
+		case ":lines":
+			value = strconv.Itoa(a.Article.Lines)
+		default:
+			value = strings.Join(strings.Fields(a.Article.Header.Get(field)), " ")
 		}
+		fmt.Fprintf(dw, "%d %s\n", a.Num, value)
 	}
-	return err
+	return nil
+}
+
+func handleNewNews(args []string, s *session, c *textproto.Conn) error {
+	if len(args) < 3 {
+		return ErrSyntax
+	}
+	since, err := parseDateTime(args[1], args[2], len(args) > 3 && strings.EqualFold(args[3], "GMT"))
+	if err != nil {
+		return ErrSyntax
+	}
+
+	items, err := s.operator.NewNews(since)
+	if err != nil {
+		return err
+	}
+
+	match := wildmat.Parse(args[0])
+	c.PrintfLine("230 list of new articles by message-id follows")
+	dw := c.DotWriter()
+	defer dw.Close()
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if seen[item.MessageID] || !match.Match(item.Group) {
+			continue
+		}
+		seen[item.MessageID] = true
+		fmt.Fprintf(dw, "%s\n", item.MessageID)
+	}
+	return nil
+}
+
+// parseDateTime parses the date and time arguments of NEWNEWS and
+// NEWGROUPS, they are in local time unless gmt is set.
+func parseDateTime(date, clock string, gmt bool) (time.Time, error) {
+	loc := time.Local
+	if gmt {
+		loc = time.UTC
+	}
+
+	layout := "20060102 150405"
+	if len(date) == 6 {
+		layout = "060102 150405"
+	}
+	return time.ParseInLocation(layout, date+" "+clock, loc)
+}
+
+// handleStartTLS switches the session to TLS, see RFC 4642.
+func handleStartTLS(args []string, s *session, c *textproto.Conn) error {
+	if s.server.TLSConfig == nil || s.tls || s.operator.Authorized() {
+		return ErrCommandUnavailable
+	}
+
+	c.PrintfLine("382 Continue with TLS negotiation")
+
+	nc := tls.Server(s.netConn, s.server.TLSConfig)
+	if err := nc.Handshake(); err != nil {
+		return fmt.Errorf("TLS handshake: %w", err)
+	}
+
+	// the client has to start over, as after a new connection
+	s.conn = textproto.NewConn(nc)
+	s.tls = true
+	s.group = nil
+	s.article = 0
+	return nil
 }
@@ -0,0 +1,632 @@
+package nntp
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"newsmere/internal/storage"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testOperator serves a single group holding the articles 1 to 3.
+type testOperator struct {
+	authorized bool
+	posted     []*Article
+}
+
+const testArticles = 3
+
+func testArticle(num int64) *NumberedArticle {
+	return &NumberedArticle{
+		Num: num,
+		Article: &Article{
+			Header: textproto.MIMEHeader{
+				"Message-Id": {fmt.Sprintf("<%d@test>", num)},
+				"Subject":    {fmt.Sprintf("article %d", num)},
+			},
+			Body:  strings.NewReader("body\n"),
+			Bytes: 5,
+			Lines: 1,
+		},
+	}
+}
+
+func (o *testOperator) ListGroups(max int) ([]*storage.Group, error) {
+	old := &storage.Group{Name: "old", Source: "test"}
+	old.CreatedAt = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	group := &storage.Group{Name: "group", Source: "test",
+		Low: 1, High: testArticles, Count: testArticles}
+	group.CreatedAt = time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	return []*storage.Group{old, group}, nil
+}
+
+func (o *testOperator) GetGroup(name string) (*storage.Group, error) {
+	if name != "test.group" {
+		return nil, ErrNoSuchGroup
+	}
+	return &storage.Group{Name: "group", Source: "test",
+		Low: 1, High: testArticles, Count: testArticles}, nil
+}
+
+func (o *testOperator) GetArticle(group *Group, id string) (*NumberedArticle, error) {
+	var num int64
+	if strings.HasPrefix(id, "<") {
+		if _, err := fmt.Sscanf(id, "<%d@test>", &num); err != nil {
+			return nil, ErrInvalidMessageID
+		}
+		if num < 1 || num > testArticles {
+			return nil, ErrInvalidMessageID
+		}
+		return testArticle(0), nil
+	}
+
+	num, _ = strconv.ParseInt(id, 10, 64)
+	if num < 1 || num > testArticles {
+		return nil, ErrInvalidArticleNumber
+	}
+	return testArticle(num), nil
+}
+
+func (o *testOperator) GetArticles(group *Group, from, to int64) ([]NumberedArticle, error) {
+	var articles []NumberedArticle
+	for n := from; n <= to && n <= testArticles; n++ {
+		if n >= 1 {
+			articles = append(articles, *testArticle(n))
+		}
+	}
+	return articles, nil
+}
+
+func (o *testOperator) ArticleNumbers(group *Group, from, to int64) ([]int64, error) {
+	var numbers []int64
+	for _, a := range must(o.GetArticles(group, from, to)) {
+		numbers = append(numbers, a.Num)
+	}
+	return numbers, nil
+}
+
+func (o *testOperator) AdjacentArticle(group *Group, num int64, next bool) (*NumberedArticle, error) {
+	switch {
+	case next && num < testArticles:
+		return testArticle(num + 1), nil
+	case next:
+		return nil, ErrNoNextArticle
+	case num > 1:
+		return testArticle(num - 1), nil
+	default:
+		return nil, ErrNoPreviousArticle
+	}
+}
+
+func (o *testOperator) NewNews(since time.Time) ([]NewsItem, error) {
+	return []NewsItem{
+		{Group: "test.group", MessageID: "<1@test>"},
+		{Group: "test.other", MessageID: "<2@test>"},
+		{Group: "test.other", MessageID: "<1@test>"},
+	}, nil
+}
+
+func must(articles []NumberedArticle, err error) []NumberedArticle {
+	if err != nil {
+		panic(err)
+	}
+	return articles
+}
+
+func (o *testOperator) Authorized() bool {
+	return o.authorized
+}
+
+func (o *testOperator) Authenticate(user, pass string) (Operator, error) {
+	if user != "user" || pass != "secret pass" {
+		return nil, ErrAuthRejected
+	}
+	return &testOperator{authorized: true}, nil
+}
+
+func (o *testOperator) CanPost() bool {
+	return o.authorized
+}
+
+func (o *testOperator) Post(article *Article) error {
+	if article.Header.Get("Newsgroups") != "test.group" {
+		return ErrPostingFailed
+	}
+	o.posted = append(o.posted, article)
+	return nil
+}
+
+type testClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, server *NNTPServer) *testClient {
+	client, conn := net.Pipe()
+	go server.Process(conn)
+
+	c := &testClient{conn: client, r: bufio.NewReader(client)}
+	if line := c.readLine(t); !strings.HasPrefix(line, "20") {
+		t.Fatalf("Unexpected greeting: %s", line)
+	}
+	return c
+}
+
+func (c *testClient) readLine(t *testing.T) string {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+func (c *testClient) cmd(t *testing.T, cmd string) string {
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.conn.Write([]byte(cmd + "\r\n")); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	return c.readLine(t)
+}
+
+func TestShutdown(t *testing.T) {
+	server := NewServer(&testOperator{})
+
+	c := dial(t, server)
+	if line := c.cmd(t, "GROUP test.group"); !strings.HasPrefix(line, "211 ") {
+		t.Fatalf("Unexpected response: %s", line)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		server.Shutdown(5 * time.Second)
+		close(done)
+	}()
+
+	if line := c.readLine(t); !strings.HasPrefix(line, "400 ") {
+		t.Errorf("Unexpected response: %s", line)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Shutdown did not return")
+	}
+
+	// new sessions are turned away
+	client, conn := net.Pipe()
+	go server.Process(conn)
+	line, _ := bufio.NewReader(client).ReadString('\n')
+	if !strings.HasPrefix(line, "400 ") {
+		t.Errorf("Unexpected greeting: %s", line)
+	}
+}
+
+// blockingOperator blocks GROUP until release is closed.
+type blockingOperator struct {
+	testOperator
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (o *blockingOperator) GetGroup(name string) (*storage.Group, error) {
+	close(o.entered)
+	<-o.release
+	return o.testOperator.GetGroup(name)
+}
+
+func TestShutdownWaitsForCommands(t *testing.T) {
+	operator := &blockingOperator{
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	server := NewServer(operator)
+
+	c := dial(t, server)
+	c.conn.Write([]byte("GROUP test.group\r\n"))
+	<-operator.entered
+
+	done := make(chan struct{})
+	go func() {
+		server.Shutdown(50 * time.Millisecond)
+		close(done)
+	}()
+
+	// the connection is closed, the command still runs
+	select {
+	case <-done:
+		t.Fatalf("Shutdown returned while a command was running")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(operator.release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Shutdown did not return")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	server := NewServer(&testOperator{})
+	server.IdleTimeout = 50 * time.Millisecond
+	server.CommandTimeout = 50 * time.Millisecond
+
+	c := dial(t, server)
+	if line := c.cmd(t, "GROUP test.group"); !strings.HasPrefix(line, "211 ") {
+		t.Fatalf("Unexpected response: %s", line)
+	}
+
+	if line := c.readLine(t); line != "400 idle timeout" {
+		t.Errorf("Unexpected response: %s", line)
+	}
+	if _, err := c.r.ReadString('\n'); err != io.EOF {
+		t.Errorf("Session not closed: %v", err)
+	}
+
+	// a client that doesn't take the response is dropped as well
+	c = dial(t, server)
+	c.conn.Write([]byte("LIST\r\n"))
+	time.Sleep(100 * time.Millisecond)
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(c.r); err != nil {
+		t.Errorf("Session not closed: %v", err)
+	}
+}
+
+func TestAuthInfo(t *testing.T) {
+	c := dial(t, NewServer(&testOperator{}))
+
+	for _, tc := range []struct {
+		cmd, code string
+	}{
+		{"AUTHINFO PASS secret", "482 "},
+		{"AUTHINFO USER user", "381 "},
+		{"AUTHINFO PASS wrong", "481 "},
+		{"AUTHINFO PASS secret pass", "482 "},
+		{"AUTHINFO USER user", "381 "},
+		{"AUTHINFO PASS secret pass", "281 "},
+		{"AUTHINFO USER user", "502 "},
+	} {
+		if line := c.cmd(t, tc.cmd); !strings.HasPrefix(line, tc.code) {
+			t.Errorf("%s: unexpected response: %s", tc.cmd, line)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{"AUTHINFO PASS secret", "AUTHINFO PASS ********"},
+		{"authinfo pass two words", "authinfo pass ********"},
+		{"AUTHINFO USER alice", "AUTHINFO USER alice"},
+		{"GROUP test.group", "GROUP test.group"},
+	}
+	for _, tt := range tests {
+		got := strings.Join(redact(strings.Split(tt.line, " ")), " ")
+		if got != tt.want {
+			t.Errorf("%q: got %q", tt.line, got)
+		}
+	}
+}
+
+func TestAuthPerSession(t *testing.T) {
+	op := &testOperator{}
+	server := NewServer(op)
+
+	c1 := dial(t, server)
+	c1.cmd(t, "AUTHINFO USER user")
+	if line := c1.cmd(t, "AUTHINFO PASS secret pass"); !strings.HasPrefix(line, "281 ") {
+		t.Fatalf("Unexpected response: %s", line)
+	}
+
+	c2 := dial(t, server)
+	if line := c2.cmd(t, "AUTHINFO USER user"); !strings.HasPrefix(line, "381 ") {
+		t.Errorf("Authentication leaked to another session: %s", line)
+	}
+	if op.Authorized() {
+		t.Errorf("Shared operator was authorized")
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	server := NewServer(&testOperator{})
+	server.RequireAuth = true
+	c := dial(t, server)
+
+	for _, tc := range []struct {
+		cmd, code string
+	}{
+		{"GROUP test.group", "480 "},
+		{"LIST", "480 "},
+		{"AUTHINFO USER user", "381 "},
+		{"AUTHINFO PASS secret pass", "281 "},
+		{"GROUP test.group", "211 "},
+	} {
+		if line := c.cmd(t, tc.cmd); !strings.HasPrefix(line, tc.code) {
+			t.Errorf("%s: unexpected response: %s", tc.cmd, line)
+		}
+	}
+}
+
+func TestPost(t *testing.T) {
+	op := &testOperator{authorized: true}
+	c := dial(t, NewServer(op))
+
+	post := func(article string) string {
+		if line := c.cmd(t, "POST"); !strings.HasPrefix(line, "340 ") {
+			t.Fatalf("Unexpected response: %s", line)
+		}
+		article = strings.ReplaceAll(article, "\n", "\r\n")
+		return c.cmd(t, article+".")
+	}
+
+	line := post("From: user@example.com\nSubject: test\nNewsgroups: test.group\n\nbody\n..dot\n")
+	if !strings.HasPrefix(line, "240 ") {
+		t.Fatalf("Unexpected response: %s", line)
+	}
+	if len(op.posted) != 1 {
+		t.Fatalf("Expected 1 posted article, got %d", len(op.posted))
+	}
+	article := op.posted[0]
+	if article.MessageID() == "" || article.Header.Get("Date") == "" {
+		t.Errorf("Missing generated headers: %v", article.Header)
+	}
+	body := make([]byte, 64)
+	n, _ := article.Body.Read(body)
+	if string(body[:n]) != "body\n.dot\n" {
+		t.Errorf("Unexpected body: %q", body[:n])
+	}
+
+	for _, article := range []string{
+		"Subject: test\nNewsgroups: test.group\n\nbody\n",
+		"From: user@example.com\nSubject: test\nNewsgroups: other.group\n\nbody\n",
+		"From: user@example.com\nSubject: test\nNewsgroups: test.group\nMessage-ID: invalid\n\nbody\n",
+	} {
+		if line := post(article); !strings.HasPrefix(line, "441 ") {
+			t.Errorf("Unexpected response: %s", line)
+		}
+	}
+
+	c = dial(t, NewServer(&testOperator{}))
+	if line := c.cmd(t, "POST"); !strings.HasPrefix(line, "440 ") {
+		t.Errorf("Unexpected response: %s", line)
+	}
+}
+
+// readBlock reads a multi-line response up to the terminating dot.
+func (c *testClient) readBlock(t *testing.T) []string {
+	var lines []string
+	for {
+		line := c.readLine(t)
+		if line == "." {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestReaderCommands(t *testing.T) {
+	c := dial(t, NewServer(&testOperator{}))
+
+	for _, tc := range []struct {
+		cmd, response string
+		block         []string
+	}{
+		{"STAT", "412 ", nil},
+		{"NEXT", "412 ", nil},
+		{"STAT <2@test>", "223 0 <0@test>", nil},
+		{"GROUP test.group", "211 3 1 3 test.group", nil},
+		{"STAT", "223 1 <1@test>", nil},
+		{"LAST", "422 ", nil},
+		{"NEXT", "223 2 <2@test>", nil},
+		{"HEAD", "221 2 <2@test>", []string{"Message-Id: <2@test>", "Subject: article 2"}},
+		{"NEXT", "223 3 <3@test>", nil},
+		{"NEXT", "421 ", nil},
+		{"STAT 1", "223 1 <1@test>", nil},
+		{"STAT 4", "423 ", nil},
+		{"LAST", "422 ", nil},
+		{"HDR Subject", "225 ", []string{"1 article 1"}},
+		{"HDR Subject 2-", "225 ", []string{"2 article 2", "3 article 3"}},
+		{"XHDR :bytes 1-2", "221 ", []string{"1 5", "2 5"}},
+		{"HDR Subject 5-9", "423 ", nil},
+		{"OVER", "224 ", []string{"1\tarticle 1\t\t\t<1@test>\t\t5\t1"}},
+		{"OVER 5-9", "423 ", nil},
+		{"XOVER 5-", "423 ", nil},
+		{"LISTGROUP test.group 2-", "211 3 1 3 test.group", []string{"2", "3"}},
+		{"LISTGROUP", "211 3 1 3 test.group", []string{"1", "2", "3"}},
+		{"NEWNEWS test.* 20200101 000000 GMT", "230 ", []string{"<1@test>", "<2@test>"}},
+		{"NEWNEWS *,!test.other 200101 000000", "230 ", []string{"<1@test>"}},
+		{"NEWNEWS test.* 2020", "501 ", nil},
+		{"LIST HEADERS", "215 ", []string{":", ":bytes", ":lines"}},
+		{"NEWGROUPS 20220601 120000 GMT", "231 ", []string{"test.group 3 1 n"}},
+		{"NEWGROUPS 191231 000000 GMT", "231 ", []string{"test.old 0 0 n", "test.group 3 1 n"}},
+		{"LIST", "215 ", []string{"test.old 0 0 n", "test.group 3 1 n"}},
+		{"LIST ACTIVE test.g*", "215 ", []string{"test.group 3 1 n"}},
+		{"LIST ACTIVE *,!*.old", "215 ", []string{"test.group 3 1 n"}},
+		{"LIST ACTIVE.TIMES test.old", "215 ", []string{"test.old 1577836800 newsmere"}},
+		{"LIST NEWSGROUPS *.group", "215 ", []string{"test.group\t"}},
+		{"LIST DISTRIB.PATS", "215 ", []string{}},
+		{"LIST UNKNOWN", "501 ", nil},
+		{"NEWGROUPS 20220601 120001 GMT", "231 ", []string{}},
+		{"NEWGROUPS 20220601", "501 ", nil},
+	} {
+		line := c.cmd(t, tc.cmd)
+		if !strings.HasPrefix(line, tc.response) {
+			t.Errorf("%s: unexpected response: %s", tc.cmd, line)
+			continue
+		}
+		if tc.block == nil {
+			continue
+		}
+		// header fields come in any order
+		block := c.readBlock(t)
+		if strings.HasPrefix(tc.cmd, "HEAD") {
+			sort.Strings(block)
+		}
+		if strings.Join(block, "|") != strings.Join(tc.block, "|") {
+			t.Errorf("%s: unexpected lines: %q", tc.cmd, block)
+		}
+	}
+
+	if line := c.cmd(t, "DATE"); len(line) != len("111 20060102150405") ||
+		!strings.HasPrefix(line, "111 ") {
+		t.Errorf("Unexpected response: %s", line)
+	}
+	if line := c.cmd(t, "HELP"); !strings.HasPrefix(line, "100 ") {
+		t.Errorf("Unexpected response: %s", line)
+	}
+	if len(c.readBlock(t)) == 0 {
+		t.Errorf("Empty help text")
+	}
+}
+
+// testTLSConfig returns a config with a self-signed certificate.
+func testTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	server := NewServer(&testOperator{})
+	server.TLSConfig = testTLSConfig(t)
+	server.RequireTLSAuth = true
+	c := dial(t, server)
+
+	c.cmd(t, "CAPABILITIES")
+	caps := strings.Join(c.readBlock(t), "|")
+	if !strings.Contains(caps, "STARTTLS") || strings.Contains(caps, "AUTHINFO") {
+		t.Errorf("Unexpected capabilities: %s", caps)
+	}
+	if line := c.cmd(t, "AUTHINFO USER user"); !strings.HasPrefix(line, "483 ") {
+		t.Errorf("Unexpected response: %s", line)
+	}
+	c.cmd(t, "GROUP test.group")
+
+	if line := c.cmd(t, "STARTTLS"); !strings.HasPrefix(line, "382 ") {
+		t.Fatalf("Unexpected response: %s", line)
+	}
+	tc := tls.Client(c.conn, &tls.Config{InsecureSkipVerify: true})
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := tc.Handshake(); err != nil {
+		t.Fatalf("Error in TLS handshake: %v", err)
+	}
+	c = &testClient{conn: tc, r: bufio.NewReader(tc)}
+
+	c.cmd(t, "CAPABILITIES")
+	caps = strings.Join(c.readBlock(t), "|")
+	if strings.Contains(caps, "STARTTLS") || !strings.Contains(caps, "AUTHINFO USER") {
+		t.Errorf("Unexpected capabilities: %s", caps)
+	}
+
+	for _, tc := range []struct {
+		cmd, code string
+	}{
+		{"STAT", "412 "},
+		{"STARTTLS", "502 "},
+		{"AUTHINFO USER user", "381 "},
+		{"AUTHINFO PASS secret pass", "281 "},
+	} {
+		if line := c.cmd(t, tc.cmd); !strings.HasPrefix(line, tc.code) {
+			t.Errorf("%s: unexpected response: %s", tc.cmd, line)
+		}
+	}
+}
+
+// listenTLS serves the server on a TLS listener and returns its address.
+func listenTLS(t *testing.T, server *NNTPServer) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	listener := tls.NewListener(l, testTLSConfig(t))
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			nc, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.Process(nc)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	server := NewServer(&testOperator{})
+	server.CommandTimeout = 50 * time.Millisecond
+	addr := listenTLS(t, server)
+
+	// a client that never starts the handshake is dropped
+	silent, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	defer silent.Close()
+	silent.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(silent); err != nil {
+		t.Errorf("Session not closed: %v", err)
+	}
+
+	// clients doing the handshake are greeted
+	tc, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	defer tc.Close()
+	tc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, _ := bufio.NewReader(tc).ReadString('\n')
+	if !strings.HasPrefix(line, "201 ") {
+		t.Errorf("Unexpected greeting: %s", line)
+	}
+}
+
+func TestShutdownDuringHandshake(t *testing.T) {
+	server := NewServer(&testOperator{})
+	server.CommandTimeout = time.Minute
+	addr := listenTLS(t, server)
+
+	silent, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	defer silent.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	server.Shutdown(5 * time.Second)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Shutdown waited %v for the handshake", d)
+	}
+}
@@ -1,9 +1,13 @@
 package nntp
 
 import (
+	"crypto/tls"
 	"io"
+	"net"
 	"net/textproto"
 	"newsmere/internal/storage"
+	"sync"
+	"time"
 )
 
 const Type = "nntp"
@@ -32,6 +36,13 @@ type NNTPError struct {
 	Msg  string
 }
 
+// ErrShuttingDown is sent to clients whose session is ended by a shutdown.
+var ErrShuttingDown = &NNTPError{400, "service shutting down"}
+
+// ErrIdleTimeout is sent to clients whose session is ended for not sending
+// a command in time.
+var ErrIdleTimeout = &NNTPError{400, "idle timeout"}
+
 // ErrNoSuchGroup is returned for a request for a group that can't be found.
 var ErrNoSuchGroup = &NNTPError{411, "No such newsgroup"}
 
@@ -49,6 +60,15 @@ var ErrInvalidArticleNumber = &NNTPError{423, "No article with that number"}
 // requires a current article when one has not been selected.
 var ErrNoCurrentArticle = &NNTPError{420, "Current article number is invalid"}
 
+// ErrNoNextArticle is returned by NEXT at the last article of a group.
+var ErrNoNextArticle = &NNTPError{421, "No next article in this group"}
+
+// ErrNoPreviousArticle is returned by LAST at the first article of a group.
+var ErrNoPreviousArticle = &NNTPError{422, "No previous article in this group"}
+
+// ErrNoArticlesInRange is returned when a range contains no articles.
+var ErrNoArticlesInRange = &NNTPError{423, "No articles in that range"}
+
 // ErrUnknownCommand is returned for unknown comands.
 var ErrUnknownCommand = &NNTPError{500, "Unknown command"}
 
@@ -71,7 +91,18 @@ var ErrNotWanted = &NNTPError{435, "Article not wanted"}
 var ErrAuthRequired = &NNTPError{450, "authorization required"}
 
 // ErrAuthRejected is returned for invalid authentication.
-var ErrAuthRejected = &NNTPError{452, "authorization rejected"}
+var ErrAuthRejected = &NNTPError{481, "Authentication failed"}
+
+// ErrAuthOutOfSequence is returned for AUTHINFO PASS without AUTHINFO USER.
+var ErrAuthOutOfSequence = &NNTPError{482, "Authentication commands issued out of sequence"}
+
+// ErrCommandUnavailable is returned for commands that can't be used in the
+// current state, e.g. AUTHINFO after authenticating.
+var ErrCommandUnavailable = &NNTPError{502, "Command unavailable"}
+
+// ErrEncryptionRequired is returned for AUTHINFO over a plain connection
+// when TLS is required for it.
+var ErrEncryptionRequired = &NNTPError{483, "Encryption required"}
 
 // ErrNotAuthenticated is returned when a command is issued that requires
 // authentication, but authentication was not provided.
@@ -91,16 +122,45 @@ type NumberedArticle struct {
 type Operator interface {
 	ListGroups(max int) ([]*storage.Group, error)
 	GetGroup(name string) (*storage.Group, error)
-	GetArticle(group *Group, id string) (*Article, error)
+	GetArticle(group *Group, id string) (*NumberedArticle, error)
 	GetArticles(group *Group, from, to int64) ([]NumberedArticle, error)
 	Authorized() bool
+	// Authenticate returns the operator for the session of the given
+	// user. The receiver is shared by all sessions, so it must be left
+	// untouched.
 	Authenticate(user, pass string) (Operator, error)
+	// CanPost reports whether the session may post to any group.
+	CanPost() bool
+	// Post stores a valid article and forwards it to the sources of its
+	// groups.
+	Post(article *Article) error
+	// ArticleNumbers returns the numbers of the articles of the group in
+	// the range, in ascending order.
+	ArticleNumbers(group *Group, from, to int64) ([]int64, error)
+	// AdjacentArticle returns the article following num in the group, or
+	// the one preceding it unless next is set.
+	AdjacentArticle(group *Group, num int64, next bool) (*NumberedArticle, error)
+	// NewNews returns the articles which arrived since the given time.
+	NewNews(since time.Time) ([]NewsItem, error)
+}
+
+// NewsItem identifies an article in a group.
+type NewsItem struct {
+	Group     string
+	MessageID string
 }
 
 type session struct {
 	server   *NNTPServer
 	operator Operator
 	group    *Group
+	// article is the number of the current article, 0 if there is none
+	article  int64
+	authUser string
+	// conn is replaced by STARTTLS, netConn is the raw connection
+	conn    *textproto.Conn
+	netConn net.Conn
+	tls     bool
 }
 
 // The Server handle.
@@ -108,12 +168,51 @@ type NNTPServer struct {
 	Handlers map[string]Handler
 	Operator Operator
 	group    *Group
+	// RequireAuth turns away commands of sessions without authentication,
+	// except those needed to authenticate.
+	RequireAuth bool
+	// TLSConfig enables STARTTLS.
+	TLSConfig *tls.Config
+	// RequireTLSAuth refuses AUTHINFO over connections without TLS.
+	RequireTLSAuth bool
+	// IdleTimeout ends sessions that send no command for that long,
+	// CommandTimeout bounds reading a command's data and writing its
+	// response. Zero means no limit.
+	IdleTimeout    time.Duration
+	CommandTimeout time.Duration
+
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	conns   map[net.Conn]bool // whether the session waits for a command
+	closing bool
 }
 
 type Service struct {
 	Host string `json:"Host"`
 	Port int    `json:"Port"`
-
+	// ShutdownTimeout is the number of seconds running commands get to
+	// finish when the service is stopped.
+	ShutdownTimeout int `json:"shutdown_timeout,omitempty"`
+	// RequireAuth makes clients authenticate before any other command.
+	RequireAuth bool `json:"require_auth,omitempty"`
+	// TLSCert and TLSKey are the paths of the PEM encoded certificate and
+	// key, they enable STARTTLS.
+	TLSCert string `json:"tls_cert,omitempty"`
+	TLSKey  string `json:"tls_key,omitempty"`
+	// TLS makes the service speak TLS right away (NNTPS) instead of
+	// offering STARTTLS.
+	TLS bool `json:"tls,omitempty"`
+	// RequireTLSAuth refuses AUTHINFO over connections without TLS.
+	RequireTLSAuth bool `json:"require_tls_auth,omitempty"`
+	// IdleTimeout is the number of seconds a client may wait before
+	// sending its next command, CommandTimeout the number of seconds a
+	// command's data and response may take.
+	IdleTimeout    int `json:"idle_timeout,omitempty"`
+	CommandTimeout int `json:"command_timeout,omitempty"`
+
+	mu       sync.Mutex
 	operator Operator
 	server   *NNTPServer
+	listener net.Listener
+	stopped  bool
 }
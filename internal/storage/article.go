@@ -0,0 +1,296 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// ChunkSize is the maximum size of a single stored body chunk.
+	ChunkSize = 64 * 1024
+	// MaxMemBody is the size up to which Spool holds a body in memory.
+	MaxMemBody = 1024 * 1024
+)
+
+var (
+	// ErrArticleExists is returned when the groups already hold an
+	// article with the same message-id.
+	ErrArticleExists = errors.New("article already exists")
+	// ErrNoSuchArticle is returned when no article with the message-id
+	// is stored.
+	ErrNoSuchArticle = errors.New("no such article")
+)
+
+// SaveArticle stores the article into the groups and splits its body into
+// chunks. Bytes and Lines are filled in from the body when the caller left
+// them empty.
+//
+// An article is stored once by its message-id. If it is stored already,
+// e.g. cross-posted to another group, it is only added to the groups not
+// holding it yet, and article is set to the stored one.
+//
+// The article gets the next local number of each group, numbers are never
+// reused so they stay stable whatever the backend does with its own.
+func SaveArticle(db *gorm.DB, article *Article, groupIDs []uint,
+	body io.Reader) error {
+	if article.MsgID == "" {
+		return errors.New("article has no message-id")
+	}
+
+	// set while the article is inserted, which fails if another writer
+	// stored it since it was looked up
+	var inserting bool
+	save := func(tx *gorm.DB) error {
+		var stored Article
+		result := tx.Where("msg_id = ?", article.MsgID).Limit(1).Find(&stored)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			*article = stored
+			added, err := addToGroups(tx, article, groupIDs)
+			if err == nil && added == 0 {
+				return ErrArticleExists
+			}
+			return err
+		}
+
+		inserting = true
+		if err := tx.Omit(clause.Associations).Create(article).Error; err != nil {
+			return err
+		}
+		inserting = false
+
+		size, lines, err := saveChunks(tx, article.ID, body)
+		if err != nil {
+			return err
+		}
+
+		if article.Bytes == 0 && article.Lines == 0 {
+			article.Bytes = size
+			article.Lines = lines
+			err := tx.Model(article).Updates(map[string]interface{}{
+				"bytes": size,
+				"lines": lines,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = addToGroups(tx, article, groupIDs)
+		return err
+	}
+
+	err := db.Transaction(save)
+	if err != nil && inserting {
+		var count int64
+		result := db.Model(&Article{}).Where("msg_id = ?", article.MsgID).Count(&count)
+		if result.Error == nil && count > 0 {
+			// the article is linked to the groups by the second attempt
+			return db.Transaction(save)
+		}
+	}
+	return err
+}
+
+// LinkArticle adds the stored article with the message-id to the groups
+// not holding it yet, e.g. when it turns up cross-posted in another group.
+func LinkArticle(db *gorm.DB, msgID string, groupIDs []uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var article Article
+		result := tx.Where("msg_id = ?", msgID).Limit(1).Find(&article)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNoSuchArticle
+		}
+
+		_, err := addToGroups(tx, &article, groupIDs)
+		return err
+	})
+}
+
+// addToGroups numbers the article in the groups not holding it yet and
+// returns how many those were. article.Groups is set to all its groups.
+func addToGroups(tx *gorm.DB, article *Article, groupIDs []uint) (int, error) {
+	var links []GroupArticle
+	err := tx.Where("article_id = ?", article.ID).Find(&links).Error
+	if err != nil {
+		return 0, err
+	}
+
+	in := make(map[uint]bool, len(links))
+	for _, l := range links {
+		in[l.GroupId] = true
+	}
+
+	added := 0
+	for _, id := range groupIDs {
+		if in[id] {
+			continue
+		}
+		in[id] = true
+
+		num, err := nextNumber(tx, id)
+		if err != nil {
+			return 0, err
+		}
+		link := GroupArticle{GroupId: id, Number: num, ArticleId: article.ID}
+		if err := tx.Omit(clause.Associations).Create(&link).Error; err != nil {
+			return 0, err
+		}
+		links = append(links, link)
+		added++
+	}
+
+	article.Groups = links
+	return added, nil
+}
+
+// nextNumber allocates the next local article number of a group and
+// updates the group's marks accordingly. The marks are raised before they
+// are read, which locks the group row until the transaction ends, so
+// concurrent writers never get the same number.
+func nextNumber(tx *gorm.DB, groupID uint) (int, error) {
+	result := tx.Model(&Group{}).Where("id = ?", groupID).Updates(map[string]interface{}{
+		"high":  gorm.Expr("high + 1"),
+		"count": gorm.Expr("count + 1"),
+	})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, gorm.ErrRecordNotFound
+	}
+
+	var group Group
+	if err := tx.First(&group, groupID).Error; err != nil {
+		return 0, err
+	}
+
+	num := group.High
+	if group.Low == 0 || group.Count == 1 {
+		err := tx.Model(&group).Update("low", num).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+	return num, nil
+}
+
+func saveChunks(tx *gorm.DB, articleID uint, body io.Reader) (int, int, error) {
+	var size, lines int
+
+	buf := make([]byte, ChunkSize)
+	for seq := 0; ; seq++ {
+		n, err := io.ReadFull(body, buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			chunk := BodyChunk{ArticleId: articleID, Seq: seq, Data: data}
+			if err := tx.Create(&chunk).Error; err != nil {
+				return 0, 0, err
+			}
+			size += n
+			lines += bytes.Count(data, []byte{'\n'})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return size, lines, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+}
+
+// OpenBody returns a reader over the stored body of an article. Chunks are
+// loaded one at a time while the body is read.
+func OpenBody(db *gorm.DB, articleID uint) io.Reader {
+	return &bodyReader{db: db, articleID: articleID}
+}
+
+type bodyReader struct {
+	db        *gorm.DB
+	articleID uint
+	seq       int
+	buf       []byte
+	done      bool
+}
+
+func (r *bodyReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		var chunk BodyChunk
+		result := r.db.Where("article_id = ? AND seq = ?", r.articleID, r.seq).
+			Limit(1).Find(&chunk)
+		if result.Error != nil {
+			return 0, result.Error
+		}
+		if result.RowsAffected == 0 {
+			r.done = true
+			return 0, io.EOF
+		}
+
+		r.buf = chunk.Data
+		r.seq++
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Spool reads r to its end and returns a reader over what was read. Up to
+// MaxMemBody bytes are kept in memory, beyond that in a temporary file
+// removed on Close.
+//
+// Bodies read off the network are spooled before SaveArticle, so that
+// its transaction doesn't wait for the network.
+func Spool(r io.Reader) (io.ReadCloser, error) {
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, r, MaxMemBody+1)
+	if err == io.EOF {
+		return io.NopCloser(&buf), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp("", "newsmere-article-*")
+	if err != nil {
+		return nil, err
+	}
+	tmp := &tempFile{f}
+	if _, err := io.Copy(f, io.MultiReader(&buf, r)); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// tempFile is a temporary file removed when it is closed.
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	if rmErr := os.Remove(f.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
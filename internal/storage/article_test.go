@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func openTestDb(t *testing.T) {
+	if err := Open(Config{Driver: DriverSqlite, DSN: ":memory:"}); err != nil {
+		t.Fatalf("Error opening storage: %v", err)
+	}
+	t.Cleanup(func() { Close() })
+}
+
+func TestSaveArticle(t *testing.T) {
+	openTestDb(t)
+	db := GetDb()
+
+	group := Group{Name: "group", Source: "test"}
+	if err := db.Create(&group).Error; err != nil {
+		t.Fatalf("Error creating group: %v", err)
+	}
+
+	body := bytes.Repeat([]byte("line\n"), ChunkSize/5+10)
+	for i, id := range []string{"<1@test>", "<2@test>"} {
+		article := Article{MsgID: id}
+		err := SaveArticle(db, &article, []uint{group.ID}, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Error saving article: %v", err)
+		}
+		if len(article.Groups) != 1 || article.Groups[0].Number != i+1 {
+			t.Errorf("Expected number %d, got %+v", i+1, article.Groups)
+		}
+		if article.Bytes != len(body) || article.Lines != ChunkSize/5+10 {
+			t.Errorf("Unexpected size: %d bytes, %d lines", article.Bytes, article.Lines)
+		}
+
+		stored, err := io.ReadAll(OpenBody(db, article.ID))
+		if err != nil {
+			t.Fatalf("Error reading body: %v", err)
+		}
+		if !bytes.Equal(stored, body) {
+			t.Errorf("Stored body differs")
+		}
+	}
+
+	err := SaveArticle(db, &Article{MsgID: "<1@test>"}, []uint{group.ID},
+		bytes.NewReader(body))
+	if !errors.Is(err, ErrArticleExists) {
+		t.Errorf("Expected ErrArticleExists, got %v", err)
+	}
+
+	db.First(&group, group.ID)
+	if group.Low != 1 || group.High != 2 || group.Count != 2 {
+		t.Errorf("Unexpected marks: %d-%d (%d)", group.Low, group.High, group.Count)
+	}
+}
+
+func TestCrossPost(t *testing.T) {
+	openTestDb(t)
+	db := GetDb()
+
+	groups := []Group{{Name: "a", Source: "test"}, {Name: "b", Source: "test"},
+		{Name: "c", Source: "test"}}
+	if err := db.Create(&groups).Error; err != nil {
+		t.Fatalf("Error creating groups: %v", err)
+	}
+	a, b, c := groups[0].ID, groups[1].ID, groups[2].ID
+
+	other := Article{MsgID: "<other@test>"}
+	if err := SaveArticle(db, &other, []uint{b}, bytes.NewReader(nil)); err != nil {
+		t.Fatalf("Error saving article: %v", err)
+	}
+
+	article := Article{MsgID: "<1@test>"}
+	err := SaveArticle(db, &article, []uint{a, b}, bytes.NewReader([]byte("body\n")))
+	if err != nil {
+		t.Fatalf("Error saving article: %v", err)
+	}
+
+	// the copy from another group is only numbered there
+	again := Article{MsgID: "<1@test>"}
+	err = SaveArticle(db, &again, []uint{c}, bytes.NewReader([]byte("other\n")))
+	if err != nil {
+		t.Fatalf("Error saving article again: %v", err)
+	}
+	if again.ID != article.ID || again.Bytes != 5 {
+		t.Errorf("Article stored twice: %+v", again)
+	}
+	if err := LinkArticle(db, "<1@test>", []uint{a, c}); err != nil {
+		t.Errorf("Error linking article: %v", err)
+	}
+	if err := LinkArticle(db, "<2@test>", []uint{a}); !errors.Is(err, ErrNoSuchArticle) {
+		t.Errorf("Expected ErrNoSuchArticle, got %v", err)
+	}
+
+	var count int64
+	db.Model(&Article{}).Count(&count)
+	if count != 2 {
+		t.Errorf("Expected 2 articles, got %d", count)
+	}
+
+	var links []GroupArticle
+	db.Where("article_id = ?", article.ID).Order("group_id").Find(&links)
+	want := map[uint]int{a: 1, b: 2, c: 1}
+	if len(links) != len(want) {
+		t.Fatalf("Unexpected groups: %+v", links)
+	}
+	for _, l := range links {
+		if l.Number != want[l.GroupId] {
+			t.Errorf("Unexpected number in group %d: %d", l.GroupId, l.Number)
+		}
+	}
+}
+
+func TestSaveArticleConcurrently(t *testing.T) {
+	openTestDb(t)
+	db := GetDb()
+
+	group := Group{Name: "group", Source: "test"}
+	if err := db.Create(&group).Error; err != nil {
+		t.Fatalf("Error creating group: %v", err)
+	}
+
+	// every article is saved twice at once
+	const n = 10
+	errs := make(chan error, 2*n)
+	for i := 0; i < 2*n; i++ {
+		go func(i int) {
+			article := Article{MsgID: fmt.Sprintf("<%d@test>", i%n)}
+			errs <- SaveArticle(db, &article, []uint{group.ID},
+				strings.NewReader("body\n"))
+		}(i)
+	}
+	exists := 0
+	for i := 0; i < 2*n; i++ {
+		err := <-errs
+		if errors.Is(err, ErrArticleExists) {
+			exists++
+		} else if err != nil {
+			t.Errorf("Error saving article: %v", err)
+		}
+	}
+	if exists != n {
+		t.Errorf("Expected %d existing articles, got %d", n, exists)
+	}
+
+	var numbers []int
+	db.Model(&GroupArticle{}).Where("group_id = ?", group.ID).
+		Order("number").Pluck("number", &numbers)
+	for i, num := range numbers {
+		if num != i+1 {
+			t.Errorf("Unexpected numbers: %v", numbers)
+			break
+		}
+	}
+	db.First(&group, group.ID)
+	if len(numbers) != n || group.Low != 1 || group.High != n || group.Count != n {
+		t.Errorf("Unexpected marks: %d-%d (%d)", group.Low, group.High, group.Count)
+	}
+}
+
+func TestSpool(t *testing.T) {
+	for _, size := range []int{0, 10, MaxMemBody, MaxMemBody + 1, 3 * MaxMemBody} {
+		body := strings.Repeat("x", size)
+		r, err := Spool(strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Error spooling %d bytes: %v", size, err)
+		}
+
+		var name string
+		if f, ok := r.(*tempFile); ok {
+			name = f.Name()
+		} else if size > MaxMemBody {
+			t.Errorf("Expected a file for %d bytes", size)
+		}
+
+		read, err := io.ReadAll(r)
+		if err != nil || string(read) != body {
+			t.Errorf("Read %d of %d bytes: %v", len(read), size, err)
+		}
+		if err := r.Close(); err != nil {
+			t.Errorf("Error closing: %v", err)
+		}
+		if _, err := os.Stat(name); name != "" && !os.IsNotExist(err) {
+			t.Errorf("Temporary file %s not removed", name)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// migrateArticleGroups moves the group and number of each article into
+// group_articles and merges the copies of cross-posted articles. Articles
+// used to be stored once per group, at first without a local number.
+func migrateArticleGroups(db *gorm.DB) error {
+	m := db.Migrator()
+	if !m.HasTable(&Article{}) || !m.HasColumn(&Article{}, "group_id") {
+		return nil
+	}
+	numbered := m.HasColumn(&Article{}, "number")
+	if !numbered && !m.HasColumn(&Group{}, "count") {
+		// the articles are counted while they are numbered
+		if err := m.AddColumn(&Group{}, "Count"); err != nil {
+			return err
+		}
+	}
+
+	// created rather than migrated, which would migrate Article along
+	// before its copies are merged
+	if !m.HasTable(&GroupArticle{}) {
+		if err := m.CreateTable(&GroupArticle{}); err != nil {
+			return err
+		}
+	}
+
+	// the tables referring to articles
+	var related []interface{}
+	for _, model := range []interface{}{&BodyChunk{}, &Tag{}} {
+		if m.HasTable(model) {
+			related = append(related, model)
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// articles are never deleted softly, the old copies would get in
+		// the way of the unique message-ids
+		err := tx.Exec("DELETE FROM articles WHERE deleted_at IS NOT NULL").Error
+		if err != nil {
+			return err
+		}
+
+		if numbered {
+			err = tx.Exec(`INSERT INTO group_articles
+				(created_at, group_id, number, article_id)
+				SELECT created_at, group_id, number, id FROM articles
+				WHERE id NOT IN (SELECT article_id FROM group_articles)`).Error
+		} else {
+			err = numberArticles(tx)
+		}
+		if err != nil {
+			return err
+		}
+
+		var dups []struct {
+			MsgID string
+			Keep  uint
+		}
+		err = tx.Table("articles").Select("msg_id, MIN(id) AS keep").
+			Group("msg_id").Having("COUNT(*) > 1").Scan(&dups).Error
+		if err != nil {
+			return err
+		}
+
+		for _, d := range dups {
+			var ids []uint
+			err := tx.Table("articles").
+				Where("msg_id = ? AND id <> ?", d.MsgID, d.Keep).
+				Pluck("id", &ids).Error
+			if err != nil {
+				return err
+			}
+
+			if err := mergeLinks(tx, d.Keep, ids); err != nil {
+				return err
+			}
+			for _, model := range related {
+				err := tx.Unscoped().Where("article_id IN ?", ids).
+					Delete(model).Error
+				if err != nil {
+					return err
+				}
+			}
+			err = tx.Exec("DELETE FROM articles WHERE id IN ?", ids).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, index := range []string{"idx_article_group_msgid", "idx_article_group_number"} {
+		if m.HasIndex(&Article{}, index) {
+			if err := m.DropIndex(&Article{}, index); err != nil {
+				return err
+			}
+		}
+	}
+	for _, column := range []string{"group_id", "number"} {
+		if !m.HasColumn(&Article{}, column) {
+			continue
+		}
+		if err := m.DropColumn(&Article{}, column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// numberArticles numbers the articles of each group in the order they were
+// stored, they had no local numbers before.
+func numberArticles(tx *gorm.DB) error {
+	var groupIDs []uint
+	err := tx.Table("articles").Distinct("group_id").Pluck("group_id", &groupIDs).Error
+	if err != nil {
+		return err
+	}
+
+	for _, groupID := range groupIDs {
+		var articles []struct {
+			ID        uint
+			CreatedAt time.Time
+		}
+		err := tx.Table("articles").Select("id, created_at").
+			Where("group_id = ?", groupID).Order("id").Scan(&articles).Error
+		if err != nil {
+			return err
+		}
+
+		links := make([]GroupArticle, len(articles))
+		for i, a := range articles {
+			links[i] = GroupArticle{
+				CreatedAt: a.CreatedAt,
+				GroupId:   groupID,
+				Number:    i + 1,
+				ArticleId: a.ID,
+			}
+		}
+		err = tx.Omit(clause.Associations).CreateInBatches(&links, 500).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&Group{}).Where("id = ?", groupID).
+			Updates(map[string]interface{}{
+				"low":   1,
+				"high":  len(links),
+				"count": len(links),
+			}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeLinks moves the links of the duplicate articles ids to the article
+// kept. Duplicates within a group, which unnumbered articles could have,
+// are removed from it.
+func mergeLinks(tx *gorm.DB, keep uint, ids []uint) error {
+	var links []GroupArticle
+	err := tx.Where("article_id = ? OR article_id IN ?", keep, ids).
+		Order("number").Find(&links).Error
+	if err != nil {
+		return err
+	}
+
+	in := make(map[uint]bool)
+	for _, l := range links {
+		if l.ArticleId == keep {
+			in[l.GroupId] = true
+		}
+	}
+	for _, l := range links {
+		if l.ArticleId == keep {
+			continue
+		}
+		if in[l.GroupId] {
+			err := tx.Delete(&GroupArticle{}, l.ID).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Model(&Group{}).Where("id = ?", l.GroupId).
+				Update("count", gorm.Expr("count - 1")).Error
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		in[l.GroupId] = true
+		err := tx.Model(&GroupArticle{}).Where("id = ?", l.ID).
+			Update("article_id", keep).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
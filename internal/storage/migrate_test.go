@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// legacyArticle is an article as stored before cross-posts were merged.
+type legacyArticle struct {
+	gorm.Model
+	MsgID   string `gorm:"uniqueIndex:idx_article_group_msgid"`
+	Number  int    `gorm:"uniqueIndex:idx_article_group_number"`
+	Title   string
+	GroupId uint `gorm:"uniqueIndex:idx_article_group_msgid;uniqueIndex:idx_article_group_number"`
+}
+
+func (legacyArticle) TableName() string {
+	return "articles"
+}
+
+func TestMigrateArticleGroups(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	if err := db.AutoMigrate(&legacyArticle{}, &BodyChunk{}, &Group{}); err != nil {
+		t.Fatalf("Error creating tables: %v", err)
+	}
+
+	groups := []Group{{Name: "a", Source: "test"}, {Name: "b", Source: "test"}}
+	db.Create(&groups)
+	articles := []legacyArticle{
+		{MsgID: "<1@test>", Number: 1, GroupId: groups[0].ID},
+		{MsgID: "<2@test>", Number: 2, GroupId: groups[0].ID},
+		{MsgID: "<1@test>", Number: 1, GroupId: groups[1].ID},
+	}
+	db.Create(&articles)
+	for _, a := range articles {
+		db.Create(&BodyChunk{ArticleId: a.ID, Data: []byte("body\n")})
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+
+	if err := Open(Config{DSN: dsn}); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	defer Close()
+	db = GetDb()
+
+	if db.Migrator().HasColumn(&Article{}, "group_id") {
+		t.Errorf("Column group_id not dropped")
+	}
+
+	var stored []Article
+	db.Preload("Groups").Order("id").Find(&stored)
+	if len(stored) != 2 || stored[0].MsgID != "<1@test>" || len(stored[0].Groups) != 2 ||
+		len(stored[1].Groups) != 1 || stored[1].Groups[0].Number != 2 {
+		t.Errorf("Unexpected articles: %+v", stored)
+	}
+
+	var chunks int64
+	db.Model(&BodyChunk{}).Count(&chunks)
+	if chunks != 2 {
+		t.Errorf("Expected 2 chunks, got %d", chunks)
+	}
+
+	// the unique message-ids are in place
+	if err := db.Create(&Article{MsgID: "<2@test>"}).Error; err == nil {
+		t.Errorf("Duplicate message-id stored")
+	}
+}
+
+// baselineArticle is an article as stored before articles were numbered
+// locally.
+type baselineArticle struct {
+	gorm.Model
+	MsgID   string
+	Title   string
+	GroupId uint
+}
+
+func (baselineArticle) TableName() string {
+	return "articles"
+}
+
+// baselineGroup is a group as stored before articles were counted.
+type baselineGroup struct {
+	gorm.Model
+	Name   string
+	Source string
+	Low    int
+	High   int
+}
+
+func (baselineGroup) TableName() string {
+	return "groups"
+}
+
+func TestMigrateBaselineArticles(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "baseline.db")
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	if err := db.AutoMigrate(&baselineArticle{}, &baselineGroup{}); err != nil {
+		t.Fatalf("Error creating tables: %v", err)
+	}
+
+	// the marks are those of the remote server
+	groups := []baselineGroup{
+		{Name: "a", Source: "test", Low: 100, High: 200},
+		{Name: "b", Source: "test", Low: 5, High: 9},
+	}
+	db.Create(&groups)
+	db.Create(&[]baselineArticle{
+		{MsgID: "<1@test>", GroupId: groups[0].ID},
+		{MsgID: "<2@test>", GroupId: groups[0].ID},
+		{MsgID: "<1@test>", GroupId: groups[1].ID},
+		// stored twice in the same group
+		{MsgID: "<2@test>", GroupId: groups[0].ID},
+	})
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+
+	if err := Open(Config{DSN: dsn}); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	defer Close()
+	db = GetDb()
+
+	var stored []Article
+	db.Preload("Groups", func(db *gorm.DB) *gorm.DB {
+		return db.Order("group_id")
+	}).Order("id").Find(&stored)
+	if len(stored) != 2 || len(stored[0].Groups) != 2 ||
+		stored[0].Groups[0].Number != 1 || stored[0].Groups[1].Number != 1 ||
+		len(stored[1].Groups) != 1 || stored[1].Groups[0].Number != 2 {
+		t.Errorf("Unexpected articles: %+v", stored)
+	}
+
+	var migrated []Group
+	db.Order("id").Find(&migrated)
+	if len(migrated) != 2 || migrated[0].Low != 1 || migrated[0].High != 3 ||
+		migrated[0].Count != 2 || migrated[1].High != 1 || migrated[1].Count != 1 {
+		t.Errorf("Unexpected groups: %+v", migrated)
+	}
+
+	// numbering goes on after the migrated articles
+	article := Article{MsgID: "<3@test>"}
+	if err := SaveArticle(db, &article, []uint{groups[0].ID}, strings.NewReader("")); err != nil {
+		t.Fatalf("Error saving article: %v", err)
+	}
+	if article.Groups[0].Number != 4 {
+		t.Errorf("Expected number 4, got %d", article.Groups[0].Number)
+	}
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ErrNoSuchSubscription is returned for operations on an unknown
+// subscription.
+var ErrNoSuchSubscription = errors.New("no such subscription")
+
+// ListSubscriptions returns the subscriptions of a source, or of all sources
+// if source is empty, ordered by source and name.
+func ListSubscriptions(db *gorm.DB, source string) ([]Subscription, error) {
+	query := db.Order("source, name")
+	if source != "" {
+		query = query.Where("source = ?", source)
+	}
+
+	var subs []Subscription
+	if err := query.Find(&subs).Error; err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// SetSubscriptionEnabled enables or disables a subscription together with
+// the group served for it.
+func SetSubscriptionEnabled(db *gorm.DB, source, name string, enabled bool) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		// mysql doesn't count rows that already had the value as
+		// affected, so the existence is checked on its own
+		var count int64
+		err := tx.Model(&Subscription{}).
+			Where("source = ? AND name = ?", source, name).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrNoSuchSubscription
+		}
+
+		err = tx.Model(&Subscription{}).
+			Where("source = ? AND name = ?", source, name).
+			Update("enabled", enabled).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&Group{}).
+			Where("source = ? AND name = ?", source, name).
+			Update("enabled", enabled).Error
+	})
+}
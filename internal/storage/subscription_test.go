@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSetSubscriptionEnabled(t *testing.T) {
+	openTestDb(t)
+	db := GetDb()
+
+	db.Create(&Subscription{Name: "go.nuts", Source: "usenet"})
+	db.Create(&Subscription{Name: "alt.test", Source: "usenet"})
+	db.Create(&Group{Name: "go.nuts", Source: "usenet"})
+
+	if err := SetSubscriptionEnabled(db, "usenet", "go.nuts", false); err != nil {
+		t.Fatalf("Error disabling subscription: %v", err)
+	}
+
+	subs, err := ListSubscriptions(db, "usenet")
+	if err != nil {
+		t.Fatalf("Error listing subscriptions: %v", err)
+	}
+	if len(subs) != 2 || subs[0].Name != "alt.test" || !subs[0].Enabled ||
+		subs[1].Name != "go.nuts" || subs[1].Enabled {
+		t.Errorf("Unexpected subscriptions: %+v", subs)
+	}
+
+	var group Group
+	db.Where("name = ?", "go.nuts").First(&group)
+	if group.Enabled {
+		t.Errorf("Group of disabled subscription is enabled")
+	}
+
+	// enabling twice is fine, the subscription exists
+	for i := 0; i < 2; i++ {
+		if err := SetSubscriptionEnabled(db, "usenet", "go.nuts", true); err != nil {
+			t.Errorf("Error enabling subscription: %v", err)
+		}
+	}
+
+	err = SetSubscriptionEnabled(db, "usenet", "missing", true)
+	if !errors.Is(err, ErrNoSuchSubscription) {
+		t.Errorf("Expected ErrNoSuchSubscription, got %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SaveSyncState stores the sync state of a backend, replacing the one
+// stored before.
+func SaveSyncState(db *gorm.DB, state *SyncState) error {
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "backend"}},
+		DoUpdates: clause.AssignmentColumns([]string{"updated_at", "running",
+			"last_start", "last_sync", "last_error", "next_sync"}),
+	}).Create(state).Error
+}
+
+// ListSyncStates returns the sync states of all backends ordered by
+// backend.
+func ListSyncStates(db *gorm.DB) ([]SyncState, error) {
+	var states []SyncState
+	if err := db.Order("backend").Find(&states).Error; err != nil {
+		return nil, err
+	}
+	return states, nil
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSaveSyncState(t *testing.T) {
+	openTestDb(t)
+	db := GetDb()
+
+	start := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, state := range []SyncState{
+		{Backend: "rss-feeds", LastStart: start, LastSync: start},
+		{Backend: "nntp-usenet", Running: true, LastStart: start},
+		// a later sync replaces the state
+		{Backend: "nntp-usenet", LastStart: start, LastSync: start.Add(time.Minute),
+			LastError: "connection refused"},
+	} {
+		if err := SaveSyncState(db, &state); err != nil {
+			t.Fatalf("Error saving %s: %v", state.Backend, err)
+		}
+	}
+
+	states, err := ListSyncStates(db)
+	if err != nil {
+		t.Fatalf("Error listing sync states: %v", err)
+	}
+	if len(states) != 2 || states[0].Backend != "nntp-usenet" ||
+		states[0].Running || states[0].LastError != "connection refused" ||
+		!states[0].LastSync.Equal(start.Add(time.Minute)) ||
+		states[1].Backend != "rss-feeds" || states[1].LastError != "" {
+		t.Errorf("Unexpected sync states: %+v", states)
+	}
+}
@@ -1,42 +1,129 @@
 package storage
 
 import (
+	"fmt"
+	"time"
+
 	"gorm.io/datatypes"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
+const (
+	DriverSqlite   = "sqlite"
+	DriverMysql    = "mysql"
+	DriverPostgres = "postgres"
+
+	defaultDSN = "newsmere.db"
+)
+
 var manager *Manager
 
 type Manager struct {
 	db *gorm.DB
 }
 
-func init() {
-	_ = GetManager()
+// Config selects the database. For sqlite the DSN is a file name, or
+// ":memory:" for a database living as long as the process. MySQL DSNs need
+// parseTime=true, e.g. "user:pass@tcp(host:3306)/newsmere?parseTime=true".
+type Config struct {
+	Driver string `json:"driver"`
+	DSN    string `json:"dsn"`
 }
 
-func GetManager() *Manager {
-	if manager == nil {
-		db, err := gorm.Open(sqlite.Open("test.db"), &gorm.Config{})
-		if err != nil {
-			panic("failed to connect database")
-		}
+// mysqlStringSize is the size of string columns on MySQL, which can't
+// index text columns. 191 characters of utf8mb4 fit the smallest index key
+// size of InnoDB, strings that may be longer are sized on their own or
+// tagged as text. Indexes of up to 3072 bytes need the DYNAMIC row format,
+// the default since MySQL 5.7.
+const mysqlStringSize = 191
+
+// Open connects to the configured database and migrates the tables, it
+// has to be called before any other function of this package.
+func Open(config Config) error {
+	dialector, err := newDialector(config)
+	if err != nil {
+		return err
+	}
 
-		err = db.AutoMigrate(
-			&User{},
-			&Article{},
-			&Group{},
-			&Topic{},
-			&Subscription{},
-		)
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to connect database: %w", err)
+	}
+
+	if dialector.Name() == DriverSqlite {
+		sqlDB, err := db.DB()
 		if err != nil {
-			panic("failed to automigrate tables")
+			return err
 		}
+		// sqlite allows a single writer, and every connection to an
+		// in-memory database would get a database of its own
+		sqlDB.SetMaxOpenConns(1)
+	}
 
-		manager = &Manager{
-			db: db,
+	if err := migrate(db); err != nil {
+		return err
+	}
+
+	if err := Close(); err != nil {
+		return err
+	}
+	manager = &Manager{
+		db: db,
+	}
+	return nil
+}
+
+func newDialector(config Config) (gorm.Dialector, error) {
+	switch config.Driver {
+	case "", DriverSqlite, "sqlite3":
+		dsn := config.DSN
+		if dsn == "" {
+			dsn = defaultDSN
 		}
+		return sqlite.Open(dsn), nil
+	case DriverMysql:
+		return mysql.New(mysql.Config{
+			DSN:               config.DSN,
+			DefaultStringSize: mysqlStringSize,
+		}), nil
+	case DriverPostgres, "postgresql":
+		return postgres.Open(config.DSN), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver: %s", config.Driver)
+	}
+}
+
+// models are the tables of the database.
+var models = []interface{}{
+	&User{},
+	&Article{},
+	&GroupArticle{},
+	&BodyChunk{},
+	&Group{},
+	&Topic{},
+	&Subscription{},
+	&Watermark{},
+	&SyncState{},
+}
+
+// migrate brings the tables up to date.
+func migrate(db *gorm.DB) error {
+	if err := migrateArticleGroups(db); err != nil {
+		return fmt.Errorf("failed to migrate articles: %w", err)
+	}
+
+	if err := db.AutoMigrate(models...); err != nil {
+		return fmt.Errorf("failed to automigrate tables: %w", err)
+	}
+	return nil
+}
+
+func GetManager() *Manager {
+	if manager == nil {
+		panic("storage is not opened")
 	}
 	return manager
 }
@@ -45,36 +132,84 @@ func GetDb() *gorm.DB {
 	return GetManager().db
 }
 
+// Close flushes and closes the database.
+func Close() error {
+	if manager == nil {
+		return nil
+	}
+
+	db, err := manager.db.DB()
+	if err != nil {
+		return err
+	}
+
+	manager = nil
+	return db.Close()
+}
+
 type User struct {
 	gorm.Model
-	Name    string
-	Pass    string
-	IsAdmin bool
-	Active  bool
+	Name     string `gorm:"uniqueIndex"`
+	PassHash string
+	IsAdmin  bool
+	Active   bool
+	// Role groups users for access control.
+	Role string
 }
 
 type Article struct {
 	gorm.Model
-	MsgID   string
-	DocType string
-	Bytes   int
-	Lines   int
-	Title   string
-	Headers datatypes.JSON
-	GroupId uint
-	Tags    []Tag
+	// MsgID is sized for the longest message-id RFC 3977 allows.
+	MsgID      string `gorm:"size:250;uniqueIndex"`
+	DocType    string
+	Bytes      int
+	Lines      int
+	Title      string `gorm:"type:text"`
+	Author     string `gorm:"type:text"`
+	PostedAt   time.Time
+	References string `gorm:"type:text"`
+	Headers    datatypes.JSON
+	// Server is the server of the source the article was fetched from,
+	// empty for articles posted here.
+	Server string
+	// Groups places the article, which is stored once, in each group it
+	// was posted to.
+	Groups []GroupArticle
+	Tags   []Tag
+	Chunks []BodyChunk
+}
+
+// GroupArticle places an article in a group under the local number it has
+// there.
+type GroupArticle struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	GroupId   uint `gorm:"uniqueIndex:idx_group_article_number;uniqueIndex:idx_group_article_article"`
+	Number    int  `gorm:"uniqueIndex:idx_group_article_number"`
+	ArticleId uint `gorm:"uniqueIndex:idx_group_article_article;index"`
+	Article   Article
+}
+
+// BodyChunk holds a slice of an article body, bodies are split so that
+// they never have to be held in memory as a whole.
+type BodyChunk struct {
+	gorm.Model
+	ArticleId uint `gorm:"index:idx_chunk_article_seq"`
+	Seq       int  `gorm:"index:idx_chunk_article_seq"`
+	Data      []byte
 }
 
 type Group struct {
 	gorm.Model
 	Name        string `gorm:"uniqueIndex:idx_group_name_source"`
-	Description string
-	Articles    []Article
+	Description string `gorm:"type:text"`
+	Articles    []GroupArticle
 	Source      string `gorm:"uniqueIndex:idx_group_name_source"`
 	TopicId     uint
 	Enabled     bool `gorm:"default:true"`
 	Low         int
 	High        int
+	Count       int
 }
 
 type Topic struct {
@@ -88,10 +223,28 @@ type Topic struct {
 type Subscription struct {
 	gorm.Model
 	Name        string `gorm:"uniqueIndex:idx_sub_name_source"`
-	Description string
+	Description string `gorm:"type:text"`
 	High        int
 	Low         int
 	Source      string `gorm:"uniqueIndex:idx_sub_name_source"`
+	// Fetched is the number of the last remote article already stored,
+	// unlike High and Low it is never overwritten by the remote server.
+	Fetched int
+	// Validity identifies the numbering Fetched refers to, for IMAP the
+	// UIDVALIDITY of the mailbox.
+	Validity int
+	// Enabled selects the subscription to be served as a group.
+	Enabled bool `gorm:"default:true"`
+}
+
+// Watermark is the number of the last article of a subscription fetched
+// from one server of its source, as each server numbers the articles of a
+// group on its own.
+type Watermark struct {
+	gorm.Model
+	SubscriptionId uint   `gorm:"uniqueIndex:idx_watermark_sub_server"`
+	Server         string `gorm:"uniqueIndex:idx_watermark_sub_server"`
+	Fetched        int
 }
 
 type Tag struct {
@@ -99,3 +252,15 @@ type Tag struct {
 	Name      string
 	ArticleId uint
 }
+
+// SyncState is the outcome of the syncs of a backend, kept so that it can
+// be reported from outside the running process.
+type SyncState struct {
+	gorm.Model
+	Backend   string `gorm:"uniqueIndex"`
+	Running   bool
+	LastStart time.Time
+	LastSync  time.Time
+	LastError string `gorm:"type:text"`
+	NextSync  time.Time
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// sqlRecorder is a logger keeping the statements gorm runs.
+type sqlRecorder struct {
+	logger.Interface
+	sql []string
+}
+
+func (r *sqlRecorder) LogMode(logger.LogLevel) logger.Interface {
+	return r
+}
+
+func (r *sqlRecorder) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	sql, _ := fc()
+	r.sql = append(r.sql, sql)
+}
+
+func TestCreateTablesDryRun(t *testing.T) {
+	tests := []struct {
+		driver  string
+		columns []string
+	}{
+		{DriverMysql, []string{"`msg_id` varchar(250)", "`references` text"}},
+		{DriverPostgres, []string{`"msg_id" varchar(250)`, `"references" text`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.driver, func(t *testing.T) {
+			dialector, err := newDialector(Config{Driver: tt.driver})
+			if err != nil {
+				t.Fatalf("Error creating dialector: %v", err)
+			}
+			if d, ok := dialector.(*mysql.Dialector); ok {
+				// asking for the version needs a server
+				d.Config.SkipInitializeWithVersion = true
+			}
+
+			recorder := &sqlRecorder{Interface: logger.Discard}
+			db, err := gorm.Open(dialector, &gorm.Config{
+				DryRun:               true,
+				DisableAutomaticPing: true,
+				Logger:               recorder,
+			})
+			if err != nil {
+				t.Fatalf("Error opening database: %v", err)
+			}
+			// migrating asks the server for the existing tables, creating
+			// them runs the same DDL without one
+			if err := db.Migrator().CreateTable(models...); err != nil {
+				t.Fatalf("Error migrating: %v", err)
+			}
+
+			var create string
+			for _, sql := range recorder.sql {
+				if strings.HasPrefix(sql, "CREATE TABLE `articles`") ||
+					strings.HasPrefix(sql, `CREATE TABLE "articles"`) {
+					create = sql
+				}
+			}
+			for _, column := range tt.columns {
+				if !strings.Contains(create, column) {
+					t.Errorf("Expected %s in %q", column, create)
+				}
+			}
+		})
+	}
+}
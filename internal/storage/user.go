@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrUserExists is returned when creating a user whose name is taken.
+	ErrUserExists = errors.New("user already exists")
+	// ErrNoSuchUser is returned for operations on an unknown user.
+	ErrNoSuchUser = errors.New("no such user")
+	// ErrInvalidCredentials is returned for unknown or inactive users and
+	// wrong passwords alike.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// dummyHash is compared against for unknown users, so that they can't be
+// told apart from wrong passwords by timing.
+var dummyHash = []byte("$2a$10$/mshJRwRpVQFXaXhjwBAx.SH9n.O5iXC1j4h6DbpR1SZSi4Xel7dW")
+
+// CreateUser adds an active user.
+func CreateUser(db *gorm.DB, name, pass string, admin bool) (*User, error) {
+	var count int64
+	db.Model(&User{}).Where("name = ?", name).Count(&count)
+	if count > 0 {
+		return nil, ErrUserExists
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user := User{
+		Name:     name,
+		PassHash: string(hash),
+		IsAdmin:  admin,
+		Active:   true,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// SetPassword replaces the password of a user.
+func SetPassword(db *gorm.DB, name, pass string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return updateUser(db, name, "pass_hash", string(hash))
+}
+
+// SetUserActive enables or disables a user.
+func SetUserActive(db *gorm.DB, name string, active bool) error {
+	return updateUser(db, name, "active", active)
+}
+
+// SetUserRole sets the role of a user, an empty role removes it.
+func SetUserRole(db *gorm.DB, name, role string) error {
+	return updateUser(db, name, "role", role)
+}
+
+func updateUser(db *gorm.DB, name, column string, value interface{}) error {
+	result := db.Model(&User{}).Where("name = ?", name).Update(column, value)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNoSuchUser
+	}
+	return nil
+}
+
+// CheckUser returns the active user with the given credentials.
+func CheckUser(db *gorm.DB, name, pass string) (*User, error) {
+	var user User
+	result := db.Where("name = ?", name).Limit(1).Find(&user)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(pass))
+		return nil, ErrInvalidCredentials
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(user.PassHash), []byte(pass))
+	if err != nil || !user.Active {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &user, nil
+}
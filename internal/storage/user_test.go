@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckUser(t *testing.T) {
+	openTestDb(t)
+	db := GetDb()
+
+	if _, err := CreateUser(db, "alice", "secret", false); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	if _, err := CreateUser(db, "alice", "other", false); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Expected ErrUserExists, got %v", err)
+	}
+
+	if _, err := CheckUser(db, "alice", "secret"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	for _, pass := range []string{"wrong", ""} {
+		if _, err := CheckUser(db, "alice", pass); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Expected ErrInvalidCredentials for %q, got %v", pass, err)
+		}
+	}
+	if _, err := CheckUser(db, "bob", "secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials, got %v", err)
+	}
+
+	if err := SetUserActive(db, "alice", false); err != nil {
+		t.Fatalf("Error disabling user: %v", err)
+	}
+	if _, err := CheckUser(db, "alice", "secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Disabled user authenticated: %v", err)
+	}
+	SetUserActive(db, "alice", true)
+
+	if err := SetPassword(db, "alice", "changed"); err != nil {
+		t.Fatalf("Error setting password: %v", err)
+	}
+	if _, err := CheckUser(db, "alice", "changed"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := SetPassword(db, "bob", "secret"); !errors.Is(err, ErrNoSuchUser) {
+		t.Errorf("Expected ErrNoSuchUser, got %v", err)
+	}
+}
@@ -5,4 +5,7 @@ type StatusText string
 const (
 	StatusUp   = "up"
 	StatusDown = "down"
+	// StatusDegraded is reported while a component works only in part,
+	// e.g. while it reconnects.
+	StatusDegraded = "degraded"
 )
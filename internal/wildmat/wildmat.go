@@ -0,0 +1,93 @@
+// Package wildmat implements the wildmat patterns of RFC 3977 section 4.
+//
+// A wildmat is a comma separated list of patterns, a pattern prefixed with
+// "!" is negated. The last pattern matching a string decides, a string no
+// pattern matches doesn't match the wildmat. Within a pattern "*" matches
+// any sequence of characters and "?" a single character.
+package wildmat
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Wildmat is a parsed wildmat.
+type Wildmat []Pattern
+
+// Pattern is a single element of a wildmat.
+type Pattern struct {
+	Text    string
+	Negated bool
+}
+
+// Parse splits a wildmat into its patterns.
+func Parse(wildmat string) Wildmat {
+	var w Wildmat
+	for _, p := range strings.Split(wildmat, ",") {
+		if strings.HasPrefix(p, "!") {
+			w = append(w, Pattern{Text: p[1:], Negated: true})
+		} else {
+			w = append(w, Pattern{Text: p})
+		}
+	}
+	return w
+}
+
+// Match reports whether s matches the wildmat.
+func Match(wildmat, s string) bool {
+	return Parse(wildmat).Match(s)
+}
+
+// Match reports whether s matches the wildmat.
+func (w Wildmat) Match(s string) bool {
+	for i := len(w) - 1; i >= 0; i-- {
+		if w[i].Match(s) {
+			return !w[i].Negated
+		}
+	}
+	return false
+}
+
+// Match reports whether s matches the pattern, regardless of negation.
+func (p Pattern) Match(s string) bool {
+	return match(p.Text, s)
+}
+
+func match(pattern, s string) bool {
+	// star and rest remember the last "*" to backtrack to
+	star, rest := -1, 0
+	pi, si := 0, 0
+	for si < len(s) {
+		if pi < len(pattern) {
+			switch pattern[pi] {
+			case '*':
+				star, rest = pi, si
+				pi++
+				continue
+			case '?':
+				_, n := utf8.DecodeRuneInString(s[si:])
+				pi++
+				si += n
+				continue
+			default:
+				if pattern[pi] == s[si] {
+					pi++
+					si++
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		// let the last "*" take one more character
+		_, n := utf8.DecodeRuneInString(s[rest:])
+		rest += n
+		pi, si = star+1, rest
+	}
+
+	for pi < len(pattern) && pattern[pi] == '*' {
+		pi++
+	}
+	return pi == len(pattern)
+}
@@ -0,0 +1,37 @@
+package wildmat
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		wildmat, s string
+		match      bool
+	}{
+		{"*", "comp.lang.go", true},
+		{"*", "", true},
+		{"", "", true},
+		{"", "a", false},
+		{"comp.*", "comp.lang.go", true},
+		{"comp.*", "comp", false},
+		{"comp.*", "alt.comp", false},
+		{"*.go", "comp.lang.go", true},
+		{"*.go", "comp.lang.gopher", false},
+		{"c*.l*.g?", "comp.lang.go", true},
+		{"comp.lang.g?", "comp.lang.g", false},
+		{"?", "ü", true},
+		{"a?c", "aüc", true},
+		{"*a*a*", "banana", true},
+		{"*a*a*a*a", "banana", false},
+		{"comp.*,!comp.lang.*", "comp.lang.go", false},
+		{"comp.*,!comp.lang.*", "comp.os.linux", true},
+		{"comp.*,!comp.lang.*,comp.lang.go", "comp.lang.go", true},
+		{"!comp.*", "comp.lang.go", false},
+		{"!comp.*", "alt.test", false},
+		{"*,!comp.*", "alt.test", true},
+	} {
+		if got := Match(tc.wildmat, tc.s); got != tc.match {
+			t.Errorf("Match(%q, %q) = %v, expected %v",
+				tc.wildmat, tc.s, got, tc.match)
+		}
+	}
+}
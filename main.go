@@ -1,8 +1,205 @@
 package main
 
-import "newsmere/internal/engine"
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"newsmere/internal/engine"
+	"newsmere/internal/storage"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const usage = `usage: newsmere [-c config] [command]
+
+commands:
+  run                      run the backends and services (default)
+  user add [-admin] [-role role] <name>
+                           create a user, the password is read from stdin
+  user passwd <name>       reset the password of a user from stdin
+  user disable <name>      disable a user
+  user enable <name>       enable a user
+  user role <name> [role]  set or clear the role of a user
+  sub list [source]        list the subscriptions
+  sub enable <source> <name>
+                           serve the group of a subscription
+  sub disable <source> <name>
+                           stop serving the group of a subscription
+  status                   show the last and next sync of each backend
+`
 
 func main() {
-	e := engine.New("")
-	e.Run()
+	configFile := flag.String("c", "", "config file (default config.json)")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	e := engine.New(*configFile)
+
+	args := flag.Args()
+	if len(args) == 0 || args[0] == "run" {
+		run(e)
+		return
+	}
+
+	var err error
+	switch args[0] {
+	case "user":
+		err = userCommand(args[1:])
+	case "sub":
+		err = subCommand(args[1:])
+	case "status":
+		err = statusCommand(args[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	storage.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(e *engine.Engine) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigc
+		log.Printf("Got signal %v, shutting down", sig)
+		e.Shutdown()
+	}()
+
+	if err := e.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func userCommand(args []string) error {
+	fs := flag.NewFlagSet("user", flag.ExitOnError)
+	admin := fs.Bool("admin", false, "grant admin rights")
+	role := fs.String("role", "", "role for access control")
+	fs.Usage = flag.Usage
+	if len(args) > 0 {
+		fs.Parse(args[1:])
+	}
+	if len(args) == 0 || fs.NArg() < 1 || fs.NArg() > 2 ||
+		(fs.NArg() == 2 && args[0] != "role") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db := storage.GetDb()
+	name := fs.Arg(0)
+	switch args[0] {
+	case "add":
+		pass, err := readPassword()
+		if err != nil {
+			return err
+		}
+		if _, err = storage.CreateUser(db, name, pass, *admin); err != nil {
+			return err
+		}
+		if *role != "" {
+			return storage.SetUserRole(db, name, *role)
+		}
+		return nil
+	case "passwd":
+		pass, err := readPassword()
+		if err != nil {
+			return err
+		}
+		return storage.SetPassword(db, name, pass)
+	case "disable":
+		return storage.SetUserActive(db, name, false)
+	case "enable":
+		return storage.SetUserActive(db, name, true)
+	case "role":
+		return storage.SetUserRole(db, name, fs.Arg(1))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+func subCommand(args []string) error {
+	db := storage.GetDb()
+
+	switch {
+	case len(args) >= 1 && len(args) <= 2 && args[0] == "list":
+		source := ""
+		if len(args) == 2 {
+			source = args[1]
+		}
+		subs, err := storage.ListSubscriptions(db, source)
+		if err != nil {
+			return err
+		}
+		for _, s := range subs {
+			state := "enabled"
+			if !s.Enabled {
+				state = "disabled"
+			}
+			fmt.Printf("%s\t%s\t%s\n", s.Source, s.Name, state)
+		}
+		return nil
+	case len(args) == 3 && args[0] == "enable":
+		return storage.SetSubscriptionEnabled(db, args[1], args[2], true)
+	case len(args) == 3 && args[0] == "disable":
+		return storage.SetSubscriptionEnabled(db, args[1], args[2], false)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+func statusCommand(args []string) error {
+	if len(args) != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	states, err := storage.ListSyncStates(storage.GetDb())
+	if err != nil {
+		return err
+	}
+	for _, s := range states {
+		state := "idle"
+		if s.Running {
+			state = "running"
+		}
+		fmt.Printf("%s\t%s\tlast %s\tnext %s", s.Backend, state,
+			formatTime(s.LastSync), formatTime(s.NextSync))
+		if s.LastError != "" {
+			fmt.Printf("\terror: %s", s.LastError)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+// readPassword reads the password from the first line of stdin.
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("reading password: %w", err)
+	}
+
+	pass := strings.TrimRight(line, "\r\n")
+	if pass == "" {
+		return "", fmt.Errorf("empty password")
+	}
+	return pass, nil
 }